@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+const (
+	herokuPostgresProcid = "heroku-postgres"
+	herokuRedisProcid    = "heroku-redis"
+)
+
+var (
+	keyAddon               = []byte("addon")
+	keySamplePrefix        = []byte("sample#")
+	keyDbSize              = []byte("db_size")
+	keyTables              = []byte("tables")
+	keyActiveConnections   = []byte("active-connections")
+	keyWaitingConnections  = []byte("waiting-connections")
+	keyIndexCacheHitRate   = []byte("index-cache-hit-rate")
+	keyTableCacheHitRate   = []byte("table-cache-hit-rate")
+	keyHitRate             = []byte("hit-rate")
+	keyEvictedKeys         = []byte("evicted-keys")
+	keyReadIops            = []byte("read-iops")
+	keyWriteIops           = []byte("write-iops")
+	keyAddonLoadAvg1Min    = []byte("load-avg-1m")
+	keyAddonMemoryTotal    = []byte("memory-total")
+	keyAddonMemoryFree     = []byte("memory-free")
+	keyAddonMemoryCached   = []byte("memory-cached")
+	keyAddonMemoryPercent  = []byte("memory-percentage")
+	keyAddonMemoryPostgres = []byte("memory-postgres")
+	keyAddonMemoryRedis    = []byte("memory-redis")
+	keyTmpDiskUsed         = []byte("tmp-disk-used")
+	keyTmpDiskAvailable    = []byte("tmp-disk-available")
+)
+
+// Strips the unit off an add-on sample and returns it in bytes. Plain numbers
+// are taken as they are.
+func parseAddonBytes(val []byte) float64 {
+	s := string(val)
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "bytes"):
+		s = strings.TrimSuffix(s, "bytes")
+	case strings.HasSuffix(s, "kB"):
+		s = strings.TrimSuffix(s, "kB")
+		multiplier = float64(KB)
+	case strings.HasSuffix(s, "MB"):
+		s = strings.TrimSuffix(s, "MB")
+		multiplier = float64(MB)
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f * multiplier
+}
+
+func parseAddonFloat(val []byte) float64 {
+	f, _ := strconv.ParseFloat(string(val), 64)
+	return f
+}
+
+// source=DATABASE addon=postgresql-curved-12345 sample#db_size=4567bytes sample#tables=23
+// sample#active-connections=12 sample#waiting-connections=0 sample#index-cache-hit-rate=0.99
+// sample#table-cache-hit-rate=0.98 sample#load-avg-1m=0.1 sample#read-iops=0 sample#write-iops=1.2
+// sample#memory-total=4044740kB sample#memory-free=...
+type postgresMetricsMsg struct {
+	Source             string
+	Addon              string
+	DbSize             float64 // bytes
+	Tables             int
+	ActiveConnections  int
+	WaitingConnections int
+	IndexCacheHitRate  float64
+	TableCacheHitRate  float64
+	LoadAvg1Min        float64
+	ReadIops           float64
+	WriteIops          float64
+	MemoryTotal        float64 // bytes
+	MemoryFree         float64 // bytes
+	MemoryCached       float64 // bytes
+	MemoryPostgres     float64 // bytes
+	TmpDiskUsed        float64 // bytes
+	TmpDiskAvailable   float64 // bytes
+
+	timestamp   int64
+	sourceDrain string
+}
+
+func (pm *postgresMetricsMsg) HandleLogfmt(key, val []byte) error {
+	key = bytes.TrimPrefix(key, keySamplePrefix)
+	switch {
+	case bytes.Equal(key, keySource):
+		pm.Source = string(val)
+	case bytes.Equal(key, keyAddon):
+		pm.Addon = string(val)
+	case bytes.Equal(key, keyDbSize):
+		pm.DbSize = parseAddonBytes(val)
+	case bytes.Equal(key, keyTables):
+		pm.Tables, _ = strconv.Atoi(string(val))
+	case bytes.Equal(key, keyActiveConnections):
+		pm.ActiveConnections, _ = strconv.Atoi(string(val))
+	case bytes.Equal(key, keyWaitingConnections):
+		pm.WaitingConnections, _ = strconv.Atoi(string(val))
+	case bytes.Equal(key, keyIndexCacheHitRate):
+		pm.IndexCacheHitRate = parseAddonFloat(val)
+	case bytes.Equal(key, keyTableCacheHitRate):
+		pm.TableCacheHitRate = parseAddonFloat(val)
+	case bytes.Equal(key, keyAddonLoadAvg1Min):
+		pm.LoadAvg1Min = parseAddonFloat(val)
+	case bytes.Equal(key, keyReadIops):
+		pm.ReadIops = parseAddonFloat(val)
+	case bytes.Equal(key, keyWriteIops):
+		pm.WriteIops = parseAddonFloat(val)
+	case bytes.Equal(key, keyAddonMemoryTotal):
+		pm.MemoryTotal = parseAddonBytes(val)
+	case bytes.Equal(key, keyAddonMemoryFree):
+		pm.MemoryFree = parseAddonBytes(val)
+	case bytes.Equal(key, keyAddonMemoryCached):
+		pm.MemoryCached = parseAddonBytes(val)
+	case bytes.Equal(key, keyAddonMemoryPostgres):
+		pm.MemoryPostgres = parseAddonBytes(val)
+	case bytes.Equal(key, keyTmpDiskUsed):
+		pm.TmpDiskUsed = parseAddonBytes(val)
+	case bytes.Equal(key, keyTmpDiskAvailable):
+		pm.TmpDiskAvailable = parseAddonBytes(val)
+	}
+	return nil
+}
+
+// source=REDIS addon=redis-curved-12345 sample#active-connections=4 sample#load-avg-1m=0
+// sample#read-iops=0 sample#write-iops=0 sample#memory-total=15664264kB sample#memory-free=...
+// sample#memory-redis=... sample#memory-percentage=12.5 sample#hit-rate=0.99 sample#evicted-keys=0
+type redisMetricsMsg struct {
+	Source            string
+	Addon             string
+	ActiveConnections int
+	LoadAvg1Min       float64
+	ReadIops          float64
+	WriteIops         float64
+	MemoryTotal       float64 // bytes
+	MemoryFree        float64 // bytes
+	MemoryCached      float64 // bytes
+	MemoryRedis       float64 // bytes
+	MemoryPercentage  float64
+	HitRate           float64
+	EvictedKeys       int
+
+	timestamp   int64
+	sourceDrain string
+}
+
+func (rm *redisMetricsMsg) HandleLogfmt(key, val []byte) error {
+	key = bytes.TrimPrefix(key, keySamplePrefix)
+	switch {
+	case bytes.Equal(key, keySource):
+		rm.Source = string(val)
+	case bytes.Equal(key, keyAddon):
+		rm.Addon = string(val)
+	case bytes.Equal(key, keyActiveConnections):
+		rm.ActiveConnections, _ = strconv.Atoi(string(val))
+	case bytes.Equal(key, keyAddonLoadAvg1Min):
+		rm.LoadAvg1Min = parseAddonFloat(val)
+	case bytes.Equal(key, keyReadIops):
+		rm.ReadIops = parseAddonFloat(val)
+	case bytes.Equal(key, keyWriteIops):
+		rm.WriteIops = parseAddonFloat(val)
+	case bytes.Equal(key, keyAddonMemoryTotal):
+		rm.MemoryTotal = parseAddonBytes(val)
+	case bytes.Equal(key, keyAddonMemoryFree):
+		rm.MemoryFree = parseAddonBytes(val)
+	case bytes.Equal(key, keyAddonMemoryCached):
+		rm.MemoryCached = parseAddonBytes(val)
+	case bytes.Equal(key, keyAddonMemoryRedis):
+		rm.MemoryRedis = parseAddonBytes(val)
+	case bytes.Equal(key, keyAddonMemoryPercent):
+		rm.MemoryPercentage = parseAddonFloat(val)
+	case bytes.Equal(key, keyHitRate):
+		rm.HitRate = parseAddonFloat(val)
+	case bytes.Equal(key, keyEvictedKeys):
+		rm.EvictedKeys, _ = strconv.Atoi(string(val))
+	}
+	return nil
+}
+
+// Returns the connection limit of drain's Postgres plan, 0 for no limit.
+// Tenants on another plan than the process wide default set their own.
+func postgresConnectionLimit(drain string) int {
+	if limit := tenantConfigs.For(drain).PostgresConnectionLimit; limit > 0 {
+		return limit
+	}
+	return PostgresConnectionLimit
+}
+
+// Returns the connection limit of drain's Redis plan, 0 for no limit.
+func redisConnectionLimit(drain string) int {
+	if limit := tenantConfigs.For(drain).RedisConnectionLimit; limit > 0 {
+		return limit
+	}
+	return RedisConnectionLimit
+}
+
+// How close an add-on is to its connection limit
+func connectionState(active, limit int) string {
+	switch {
+	case limit <= 0:
+		return "ok"
+	case float64(active) >= 0.95*float64(limit):
+		return "critical"
+	case float64(active) >= 0.8*float64(limit):
+		return "warning"
+	}
+	return "ok"
+}
+
+// Cache hit rates below 99% mean the working set no longer fits in memory
+func hitRateState(rate float64) string {
+	switch {
+	case rate < 0.95:
+		return "critical"
+	case rate < 0.99:
+		return "warning"
+	}
+	return "ok"
+}
+
+// Memory use in percent of the add-on's plan
+func memoryPercentageState(percentage float64) string {
+	switch {
+	case percentage >= 90:
+		return "critical"
+	case percentage >= 80:
+		return "warning"
+	}
+	return "ok"
+}
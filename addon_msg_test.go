@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDrainPostgresMetrics(t *testing.T) {
+	group := postToDrain(t, "d.postgres-test",
+		"<134>1 2014-07-02T16:52:38.123456+00:00 host app heroku-postgres - source=DATABASE addon=postgresql-curved-12345 sample#db_size=4567bytes sample#tables=23 sample#active-connections=12 sample#waiting-connections=1 sample#index-cache-hit-rate=0.99 sample#table-cache-hit-rate=0.98 sample#load-avg-1m=0.1 sample#read-iops=0 sample#write-iops=1.2 sample#memory-total=4044740kB sample#memory-free=1000kB sample#memory-cached=2MB sample#memory-postgres=1024kB",
+	)
+
+	if n := len(group.PostgresMetrics); n != 1 {
+		t.Fatalf("Got %d postgres samples, expected 1", n)
+	}
+	pm := <-group.PostgresMetrics
+	if pm.Source != "DATABASE" || pm.Addon != "postgresql-curved-12345" || pm.sourceDrain != "d.postgres-test" {
+		t.Errorf("Unexpected source %+v", pm)
+	}
+	if pm.DbSize != 4567 || pm.Tables != 23 || pm.ActiveConnections != 12 || pm.WaitingConnections != 1 {
+		t.Errorf("Unexpected counts %+v", pm)
+	}
+	if pm.IndexCacheHitRate != 0.99 || pm.TableCacheHitRate != 0.98 || pm.WriteIops != 1.2 {
+		t.Errorf("Unexpected rates %+v", pm)
+	}
+	if pm.MemoryTotal != 4044740*float64(KB) || pm.MemoryCached != 2*float64(MB) || pm.MemoryPostgres != float64(MB) {
+		t.Errorf("Unexpected memory %+v", pm)
+	}
+}
+
+func TestDrainRedisMetrics(t *testing.T) {
+	group := postToDrain(t, "d.redis-test",
+		"<134>1 2014-07-02T16:52:38.123456+00:00 host app heroku-redis - source=REDIS addon=redis-curved-12345 sample#active-connections=4 sample#load-avg-1m=0.05 sample#read-iops=0 sample#write-iops=0 sample#memory-total=15664264kB sample#memory-free=8000000kB sample#memory-cached=1000kB sample#memory-redis=2048kB sample#memory-percentage=12.5 sample#hit-rate=0.97 sample#evicted-keys=3",
+		// Not a sample, the app's own line
+		"<134>1 2014-07-02T16:52:39.123456+00:00 host app heroku-redis - Redis connection established",
+	)
+
+	if n := len(group.RedisMetrics); n != 1 {
+		t.Fatalf("Got %d redis samples, expected 1", n)
+	}
+	rm := <-group.RedisMetrics
+	if rm.Source != "REDIS" || rm.Addon != "redis-curved-12345" || rm.ActiveConnections != 4 || rm.EvictedKeys != 3 {
+		t.Errorf("Unexpected sample %+v", rm)
+	}
+	if rm.MemoryRedis != 2*float64(MB) || rm.MemoryPercentage != 12.5 || rm.HitRate != 0.97 {
+		t.Errorf("Unexpected memory %+v", rm)
+	}
+}
+
+func TestAddonStates(t *testing.T) {
+	if s := connectionState(100, 120); s != "warning" {
+		t.Errorf("100 of 120 connections is %s, expected warning", s)
+	}
+	if s := connectionState(118, 120); s != "critical" {
+		t.Errorf("118 of 120 connections is %s, expected critical", s)
+	}
+	if s := connectionState(1000, 0); s != "ok" {
+		t.Errorf("Without a limit connections are %s, expected ok", s)
+	}
+	if s := hitRateState(0.97); s != "warning" {
+		t.Errorf("A 97%% hit rate is %s, expected warning", s)
+	}
+	if s := memoryPercentageState(91); s != "critical" {
+		t.Errorf("91%% memory is %s, expected critical", s)
+	}
+}
+
+func TestConnectionLimits(t *testing.T) {
+	configs, err := parseTenantConfigs([]byte(`{"d.1": {"postgres_connection_limit": 500, "redis_connection_limit": 40}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	defer func(c TenantConfigs) { tenantConfigs = c }(tenantConfigs)
+	tenantConfigs = configs
+
+	if limit := postgresConnectionLimit("d.1"); limit != 500 {
+		t.Errorf("Expected the tenant's Postgres limit of 500, got %d", limit)
+	}
+	if limit := redisConnectionLimit("d.1"); limit != 40 {
+		t.Errorf("Expected the tenant's Redis limit of 40, got %d", limit)
+	}
+	if limit := postgresConnectionLimit("d.2"); limit != PostgresConnectionLimit {
+		t.Errorf("Expected the default Postgres limit of %d, got %d", PostgresConnectionLimit, limit)
+	}
+	if limit := redisConnectionLimit("d.2"); limit != RedisConnectionLimit {
+		t.Errorf("Expected the default Redis limit of %d, got %d", RedisConnectionLimit, limit)
+	}
+
+	if _, err := parseTenantConfigs([]byte(`{"d.1": {"postgres_connection_limit": -1}}`)); err == nil {
+		t.Errorf("Expected a negative connection limit to be refused")
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	AnomalyRequestRate = "request rate"
+	AnomalyLatencyP95  = "latency p95"
+	AnomalyErrorRatio  = "error ratio"
+
+	// Weight of the latest window in a baseline
+	anomalyBaselineWeight = 0.05
+	// Windows a baseline has to have seen before deviations are reported
+	anomalyMinWindows = 10
+	// Router latencies kept per host and window
+	anomalyLatencySamples = 1000
+	// Hosts without traffic for this long have their baselines dropped
+	anomalySeriesTTL = 7 * 24 * time.Hour
+	// Least standard deviation of the error ratio, so that a host that never
+	// had errors isn't fine with any share of them
+	anomalyMinErrorStdDev = 0.01
+)
+
+// Exponentially weighted moving mean and variance of one series, for one hour
+// of the week if seasonal.
+type ewmaBaseline struct {
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	Windows  int     `json:"windows"`
+}
+
+func (b *ewmaBaseline) Update(x float64) {
+	if b.Windows == 0 {
+		b.Mean = x
+	} else {
+		diff := x - b.Mean
+		incr := anomalyBaselineWeight * diff
+		b.Mean += incr
+		b.Variance = (1 - anomalyBaselineWeight) * (b.Variance + diff*incr)
+	}
+	b.Windows++
+}
+
+// Standard deviations x is away from the mean. The deviation is floored at a
+// twentieth of the mean, and at minStdDev, so that series which barely move
+// don't alert on noise.
+func (b *ewmaBaseline) Score(x, minStdDev float64) float64 {
+	stdDev := math.Max(math.Max(math.Sqrt(b.Variance), 0.05*math.Abs(b.Mean)), minStdDev)
+	if stdDev == 0 {
+		return 0
+	}
+	return (x - b.Mean) / stdDev
+}
+
+// A window of one of a host's series compared to its baseline
+type anomalyEvent struct {
+	Host   string
+	Series string
+	Value  float64
+	Mean   float64
+	StdDev float64
+	Score  float64
+	State  string
+
+	timestamp   int64
+	sourceDrain string
+}
+
+// Baselines of one series of a host
+type anomalySeries struct {
+	Drain    string                `json:"drain"`
+	Host     string                `json:"host"`
+	Series   string                `json:"series"`
+	Slots    map[int]*ewmaBaseline `json:"slots"`     // by hour of the week if seasonal
+	LastSeen time.Time             `json:"last_seen"` // end of the last window with traffic
+}
+
+type anomalyWindow struct {
+	errors    int
+	latencies *reservoir
+}
+
+// AnomalyDetector keeps baselines of the request rate, p95 latency and error
+// ratio of every host and reports the windows that stray too far from them.
+type AnomalyDetector struct {
+	sync.Mutex
+	threshold   float64
+	seasonal    bool
+	windowStart time.Time
+	windows     map[string]map[string]*anomalyWindow
+	baselines   map[string]*anomalySeries // by drain, host and series
+}
+
+func NewAnomalyDetector(threshold float64, seasonal bool) *AnomalyDetector {
+	return &AnomalyDetector{
+		threshold:   threshold,
+		seasonal:    seasonal,
+		windowStart: time.Now(),
+		windows:     make(map[string]map[string]*anomalyWindow),
+		baselines:   make(map[string]*anomalySeries),
+	}
+}
+
+// Records a request to host. latency is connect + service time in ms,
+// isError is set for 5xx and H codes.
+func (d *AnomalyDetector) ObserveRouter(drain, host string, latency float64, isError bool) {
+	d.Lock()
+	defer d.Unlock()
+
+	hosts, ok := d.windows[drain]
+	if !ok {
+		hosts = make(map[string]*anomalyWindow)
+		d.windows[drain] = hosts
+	}
+	w, ok := hosts[host]
+	if !ok {
+		w = &anomalyWindow{latencies: newReservoir(anomalyLatencySamples)}
+		hosts[host] = w
+	}
+
+	w.latencies.Add(latency)
+	if isError {
+		w.errors++
+	}
+}
+
+// Compares value to the baseline of the series and then adds it. Latency and
+// errors are only anomalous going up, traffic both ways. Must be called with
+// the lock held.
+func (d *AnomalyDetector) evaluate(drain, host, series string, slot int, value float64, now time.Time) *anomalyEvent {
+	key := drain + " " + host + " " + series
+	s, ok := d.baselines[key]
+	if !ok {
+		s = &anomalySeries{Drain: drain, Host: host, Series: series, Slots: make(map[int]*ewmaBaseline)}
+		d.baselines[key] = s
+	}
+	baseline, ok := s.Slots[slot]
+	if !ok {
+		baseline = &ewmaBaseline{}
+		s.Slots[slot] = baseline
+	}
+
+	event := &anomalyEvent{
+		Host:        host,
+		Series:      series,
+		Value:       value,
+		Mean:        baseline.Mean,
+		StdDev:      math.Sqrt(baseline.Variance),
+		State:       "ok",
+		timestamp:   now.UnixNano() / int64(time.Microsecond),
+		sourceDrain: drain,
+	}
+
+	if baseline.Windows >= anomalyMinWindows {
+		minStdDev := 0.0
+		if series == AnomalyErrorRatio {
+			minStdDev = anomalyMinErrorStdDev
+		}
+		event.Score = baseline.Score(value, minStdDev)
+		deviation := event.Score
+		if series == AnomalyRequestRate {
+			deviation = math.Abs(deviation)
+		}
+		switch {
+		case deviation > 2*d.threshold:
+			event.State = "critical"
+		case deviation > d.threshold:
+			event.State = "warning"
+		}
+	}
+
+	baseline.Update(value)
+	return event
+}
+
+// Returns how the window ending at now compares to the baselines and starts a
+// new window. Hosts that had no traffic in the window get their request rate
+// compared as 0, until they have had none for anomalySeriesTTL and their
+// baselines are dropped.
+func (d *AnomalyDetector) Flush(now time.Time) []*anomalyEvent {
+	d.Lock()
+	defer d.Unlock()
+
+	seconds := now.Sub(d.windowStart).Seconds()
+	if seconds <= 0 {
+		seconds = 1
+	}
+	slot := 0
+	if d.seasonal {
+		slot = int(now.UTC().Weekday())*24 + now.UTC().Hour()
+	}
+
+	events := make([]*anomalyEvent, 0)
+	for drain, hosts := range d.windows {
+		for host, w := range hosts {
+			requests := float64(w.latencies.seen)
+			events = append(events,
+				d.evaluate(drain, host, AnomalyRequestRate, slot, requests/seconds, now),
+				d.evaluate(drain, host, AnomalyLatencyP95, slot, percentile(w.latencies.values, 0.95), now),
+				d.evaluate(drain, host, AnomalyErrorRatio, slot, ratio(float64(w.errors), requests), now),
+			)
+			for _, series := range []string{AnomalyRequestRate, AnomalyLatencyP95, AnomalyErrorRatio} {
+				d.baselines[drain+" "+host+" "+series].LastSeen = now
+			}
+		}
+	}
+
+	for key, s := range d.baselines {
+		if _, ok := d.windows[s.Drain][s.Host]; ok {
+			continue
+		}
+		switch {
+		case now.Sub(s.LastSeen) > anomalySeriesTTL:
+			delete(d.baselines, key)
+		case s.Series == AnomalyRequestRate:
+			events = append(events, d.evaluate(s.Drain, s.Host, AnomalyRequestRate, slot, 0, now))
+		}
+	}
+
+	d.windows = make(map[string]map[string]*anomalyWindow)
+	d.windowStart = now
+	return events
+}
+
+// Writes the baselines to path, so that a restart doesn't mean relearning
+// them.
+func (d *AnomalyDetector) Save(path string) error {
+	d.Lock()
+	data, err := json.Marshal(d.baselines)
+	d.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Reads the baselines saved to path. A missing file is not an error, there is
+// nothing saved yet.
+func (d *AnomalyDetector) Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	baselines := make(map[string]*anomalySeries)
+	if err := json.Unmarshal(data, &baselines); err != nil {
+		return err
+	}
+
+	// Nothing to go on without the baselines themselves
+	for key, s := range baselines {
+		if s == nil || s.Slots == nil {
+			delete(baselines, key)
+			continue
+		}
+		for slot, baseline := range s.Slots {
+			if baseline == nil {
+				delete(s.Slots, slot)
+			}
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.baselines = baselines
+	return nil
+}
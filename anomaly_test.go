@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAnomalyDetector(t *testing.T) {
+	d := NewAnomalyDetector(3, false)
+	now := time.Now()
+
+	var events []*anomalyEvent
+	for window := 0; window <= anomalyMinWindows; window++ {
+		latency := 100.0 + float64(window%3)
+		if window == anomalyMinWindows {
+			latency = 1000
+		}
+		for i := 0; i < 60; i++ {
+			d.ObserveRouter("d.1", "example.com", latency, false)
+		}
+		now = now.Add(time.Minute)
+		events = d.Flush(now)
+		if len(events) != 3 {
+			t.Fatalf("Expected 3 series, got %+v", events)
+		}
+	}
+
+	for _, event := range events {
+		expected := "ok"
+		if event.Series == AnomalyLatencyP95 {
+			expected = "critical"
+		}
+		if event.State != expected {
+			t.Errorf("Expected %s to be %s, got %+v", event.Series, expected, event)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "lumbermill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "baselines.json")
+
+	if err := d.Save(path); err != nil {
+		t.Fatalf("Unexpected error saving %q", err)
+	}
+	restarted := NewAnomalyDetector(3, false)
+	if err := restarted.Load(path); err != nil {
+		t.Fatalf("Unexpected error loading %q", err)
+	}
+	baseline := restarted.baselines["d.1 example.com "+AnomalyLatencyP95].Slots[0]
+	if baseline == nil || baseline.Windows != anomalyMinWindows+1 {
+		t.Errorf("Expected the latency baseline to survive a restart, got %+v", baseline)
+	}
+	if err := restarted.Load(filepath.Join(dir, "missing.json")); err != nil {
+		t.Errorf("Expected a missing file to be fine, got %q", err)
+	}
+
+	broken := filepath.Join(dir, "broken.json")
+	data := `{"d.1 example.com ` + AnomalyLatencyP95 + `": {"slots": {"0": null}}}`
+	if err := ioutil.WriteFile(broken, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Load(broken); err != nil {
+		t.Fatalf("Unexpected error loading %q", err)
+	}
+	if s := restarted.baselines["d.1 example.com "+AnomalyLatencyP95]; s == nil || len(s.Slots) != 0 {
+		t.Errorf("Expected the null slot to be dropped, got %+v", s)
+	}
+	restarted.evaluate("d.1", "example.com", AnomalyLatencyP95, 0, 100, time.Now())
+}
+
+// Flushes windows of 60 requests to example.com, errors of them failing,
+// a minute apart and returns the events of the last one by series.
+func flushAnomalyWindows(d *AnomalyDetector, now time.Time, windows, errors int) (time.Time, map[string]*anomalyEvent) {
+	events := make(map[string]*anomalyEvent)
+	for window := 0; window < windows; window++ {
+		for i := 0; i < 60; i++ {
+			d.ObserveRouter("d.1", "example.com", 100, i < errors)
+		}
+		now = now.Add(time.Minute)
+		for _, event := range d.Flush(now) {
+			events[event.Series] = event
+		}
+	}
+	return now, events
+}
+
+func TestAnomalyDetectorErrorsFromZero(t *testing.T) {
+	d := NewAnomalyDetector(3, false)
+	now, _ := flushAnomalyWindows(d, time.Now(), anomalyMinWindows, 0)
+
+	_, events := flushAnomalyWindows(d, now, 1, 30)
+	if event := events[AnomalyErrorRatio]; event.Value != 0.5 || event.State != "critical" {
+		t.Errorf("Expected going from no errors to 50%% to be critical, got %+v", event)
+	}
+}
+
+func TestAnomalyDetectorTrafficDrop(t *testing.T) {
+	d := NewAnomalyDetector(3, false)
+	now, _ := flushAnomalyWindows(d, time.Now(), anomalyMinWindows, 0)
+
+	// No requests at all
+	now = now.Add(time.Minute)
+	events := d.Flush(now)
+	if len(events) != 1 {
+		t.Fatalf("Expected only the request rate without traffic, got %+v", events)
+	}
+	if event := events[0]; event.Series != AnomalyRequestRate || event.Value != 0 || event.State != "critical" {
+		t.Errorf("Expected traffic dropping to nothing to be critical, got %+v", event)
+	}
+
+	// Long gone
+	if events := d.Flush(now.Add(anomalySeriesTTL + time.Minute)); len(events) != 0 {
+		t.Errorf("Expected no events for an expired host, got %+v", events)
+	}
+	if len(d.baselines) != 0 {
+		t.Errorf("Expected the baselines of an expired host to be dropped, got %+v", d.baselines)
+	}
+}
+
+func TestAnomalyDetectorSeasonal(t *testing.T) {
+	d := NewAnomalyDetector(3, true)
+	monday := time.Date(2014, 7, 7, 9, 0, 0, 0, time.UTC)
+	flushAnomalyWindows(d, monday, anomalyMinWindows, 0)
+
+	// Same traffic, an hour later, has a baseline of its own to learn
+	_, events := flushAnomalyWindows(d, monday.Add(time.Hour), 1, 30)
+	if event := events[AnomalyErrorRatio]; event.State != "ok" || event.Score != 0 {
+		t.Errorf("Expected a new hour of the week to have no baseline yet, got %+v", event)
+	}
+
+	// Back in the learned hour, a week later
+	_, events = flushAnomalyWindows(d, monday.Add(7*24*time.Hour), 1, 30)
+	if event := events[AnomalyErrorRatio]; event.State != "critical" {
+		t.Errorf("Expected the learned hour to know errors are new, got %+v", event)
+	}
+
+	slots := d.baselines["d.1 example.com "+AnomalyErrorRatio].Slots
+	if len(slots) != 2 || slots[1*24+9] == nil || slots[1*24+10] == nil {
+		t.Errorf("Expected baselines for Monday 9:00 and 10:00, got %+v", slots)
+	}
+}
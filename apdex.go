@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// T when neither the tenant nor APDEX_T set one, in ms
+const defaultApdexT = 500
+
+// Apdex of one route of a host over one aggregation window. Requests served
+// within T are satisfied, within 4T tolerating, and slower ones, 5xx and H
+// codes frustrated.
+type apdexRollup struct {
+	Host       string
+	Route      string
+	T          float64 // ms
+	Satisfied  int
+	Tolerating int
+	Frustrated int
+	Score      float64
+
+	timestamp   int64
+	sourceDrain string
+}
+
+type apdexKey struct {
+	host, route string
+}
+
+type apdexWindow struct {
+	t                                 float64
+	satisfied, tolerating, frustrated int
+}
+
+// ApdexAggregator scores router lines per drain, host and route.
+type ApdexAggregator struct {
+	sync.Mutex
+	defaultT float64
+	drains   map[string]map[apdexKey]*apdexWindow
+}
+
+func NewApdexAggregator(defaultT float64) *ApdexAggregator {
+	return &ApdexAggregator{defaultT: defaultT, drains: make(map[string]map[apdexKey]*apdexWindow)}
+}
+
+// Records a request to route. latency is connect + service time in ms,
+// isError is set for 5xx and H codes.
+func (a *ApdexAggregator) ObserveRouter(drain, host, route string, latency float64, isError bool) {
+	if route == "" {
+		return
+	}
+	t := tenantConfigs.For(drain).ApdexT
+	if t <= 0 {
+		t = a.defaultT
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	routes, ok := a.drains[drain]
+	if !ok {
+		routes = make(map[apdexKey]*apdexWindow)
+		a.drains[drain] = routes
+	}
+	key := apdexKey{host, route}
+	w, ok := routes[key]
+	if !ok {
+		w = &apdexWindow{t: t}
+		routes[key] = w
+	}
+
+	switch {
+	case isError || latency > 4*t:
+		w.frustrated++
+	case latency > t:
+		w.tolerating++
+	default:
+		w.satisfied++
+	}
+}
+
+// Returns the rollups for the window ending at now and starts a new window.
+func (a *ApdexAggregator) Flush(now time.Time) []*apdexRollup {
+	a.Lock()
+	drains := a.drains
+	a.drains = make(map[string]map[apdexKey]*apdexWindow)
+	a.Unlock()
+
+	timestamp := now.UnixNano() / int64(time.Microsecond)
+	rollups := make([]*apdexRollup, 0)
+	for drain, routes := range drains {
+		for key, w := range routes {
+			total := float64(w.satisfied + w.tolerating + w.frustrated)
+			rollups = append(rollups, &apdexRollup{
+				Host:        key.host,
+				Route:       key.route,
+				T:           w.t,
+				Satisfied:   w.satisfied,
+				Tolerating:  w.tolerating,
+				Frustrated:  w.frustrated,
+				Score:       ratio(float64(w.satisfied)+float64(w.tolerating)/2, total),
+				timestamp:   timestamp,
+				sourceDrain: drain,
+			})
+		}
+	}
+	return rollups
+}
+
+// Apdex scores of 0.85 and up are good, 0.7 and up fair and below that poor
+func apdexState(score float64) string {
+	switch {
+	case score < 0.7:
+		return "critical"
+	case score < 0.85:
+		return "warning"
+	}
+	return "ok"
+}
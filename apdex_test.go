@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApdex(t *testing.T) {
+	a := NewApdexAggregator(100)
+	for _, latency := range []float64{50, 100, 150, 400, 401} {
+		a.ObserveRouter("d.1", "example.com", "/users/:id", latency, false)
+	}
+	a.ObserveRouter("d.1", "example.com", "/users/:id", 10, true)
+
+	rollups := a.Flush(time.Now())
+	if len(rollups) != 1 {
+		t.Fatalf("Expected one rollup, got %+v", rollups)
+	}
+	ar := rollups[0]
+	if ar.Satisfied != 2 || ar.Tolerating != 2 || ar.Frustrated != 2 || ar.Score != 0.5 {
+		t.Errorf("Unexpected rollup %+v", *ar)
+	}
+	if apdexState(ar.Score) != "critical" {
+		t.Errorf("Expected an Apdex of 0.5 to be critical")
+	}
+	if rollups := a.Flush(time.Now()); len(rollups) != 0 {
+		t.Errorf("Expected the window to be reset, got %+v", rollups)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/heroku/slog"
+	"github.com/kr/logfmt"
+)
+
+var (
+	appRequestIdKeys = []string{"request_id", "request-id", "requestId"}
+)
+
+// Collects the key=value pairs of an app line, or the fields of a JSON one.
+// Bare words, which is all a non logfmt line consists of, are skipped.
+func parseAppFields(msg []byte) map[string]string {
+	if fields, ok := parseJSONFields(msg); ok {
+		return fields
+	}
+
+	fields := make(map[string]string)
+	logfmt.Unmarshal(msg, logfmt.HandlerFunc(func(key, val []byte) error {
+		if len(val) > 0 {
+			fields[string(key)] = string(val)
+		}
+		return nil
+	}))
+	return fields
+}
+
+// Finds the request id an app line was logged for, either as a field or as a
+// leading "[<request id>]" tag like Rails' tagged logging adds.
+func appRequestId(msg []byte, fields map[string]string) string {
+	for _, key := range appRequestIdKeys {
+		if id, ok := fields[key]; ok {
+			return id
+		}
+	}
+
+	if len(msg) > 0 && msg[0] == '[' {
+		if end := bytes.IndexByte(msg, ']'); end > 1 && looksLikeRequestId(msg[1:end]) {
+			return string(msg[1:end])
+		}
+	}
+	return ""
+}
+
+// Router request ids are UUIDs, e.g. 1f3ed8a9-c80c-49de-a4af-2df9f4ddb858
+func looksLikeRequestId(b []byte) bool {
+	if len(b) != 36 {
+		return false
+	}
+	for i, c := range b {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !isHexDigit(c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// Runs the app output parsers, and failing those the tenant's rules, over a
+// user line from app (the syslog app name). Returns false if none of them
+// made anything of it.
+func handleUserLine(ctx slog.Context, chanGroup *ChanGroup, drain, release, app, dyno string, msg []byte, timestamp int64) bool {
+	known := false
+	fields := parseAppFields(msg)
+
+	logLevels.Observe(drain, dyno, detectLogLevel(msg, fields))
+
+	if requestId := appRequestId(msg, fields); requestId != "" {
+		ctx.Count("lines.user.correlated", 1)
+		requestCorrelator.ObserveApp(drain, requestId, fields, timestamp)
+		known = true
+	}
+
+	// Exceptions go first, their traces would look like anything else
+	isException, groups := exceptionDetector.Observe(drain, release, dyno, msg, timestamp)
+	for _, group := range groups {
+		ctx.Count("exceptions.new", 1)
+		chanGroup.ExceptionGroups <- group
+	}
+	if isException {
+		ctx.Count("lines.user.exception", 1)
+		return true
+	}
+
+	if sq, ok := parseBytesToSlowQuery(msg); ok {
+		ctx.Count("lines.user.postgres.slow_query", 1)
+		sq.timestamp = timestamp
+		sq.sourceDrain = drain
+		slowQueries.Observe(&sq)
+		return true
+	}
+
+	if ar, ok := railsRequests.Observe(drain, dyno, msg); ok {
+		ctx.Count("lines.user.rails", 1)
+		if ar != nil {
+			ar.Route = routeNormalizer.Route(drain, ar.Path)
+			ar.timestamp = timestamp
+			ar.sourceDrain = drain
+			ar.release = release
+			chanGroup.AppRequests <- ar
+		}
+		return true
+	}
+
+	if ar, ok := parseBytesToRackRequest(msg); ok {
+		ctx.Count("lines.user.rack", 1)
+		ar.Dyno = dyno
+		ar.Route = routeNormalizer.Route(drain, ar.Path)
+		ar.timestamp = timestamp
+		ar.sourceDrain = drain
+		ar.release = release
+		chanGroup.AppRequests <- &ar
+		return true
+	}
+
+	if sj, ok := parseBytesToSidekiqJob(msg, fields); ok {
+		ctx.Count("lines.user.sidekiq", 1)
+		sj.Dyno = dyno
+		sj.timestamp = timestamp
+		sj.sourceDrain = drain
+		sj.release = release
+		chanGroup.SidekiqJobs <- &sj
+		return true
+	}
+
+	if applyRules(ctx, chanGroup, drain, release, app, dyno, msg, fields, timestamp) {
+		ctx.Count("lines.rule", 1)
+		return true
+	}
+
+	return known
+}
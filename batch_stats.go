@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/heroku/slog"
+)
+
+// Per drain tally of batches whose framing didn't check out, either because
+// the lpx reader failed partway through the body or because the number of
+// lines we parsed didn't match the Logplex-Msg-Count header.
+type drainBatchStats struct {
+	Batches    int
+	Truncated  int
+	Mismatched int
+	LostLines  int
+}
+
+type BatchStats struct {
+	sync.Mutex
+	drains map[string]*drainBatchStats
+}
+
+func NewBatchStats() *BatchStats {
+	return &BatchStats{drains: make(map[string]*drainBatchStats)}
+}
+
+// Records a batch for drain. expected is the Logplex-Msg-Count header value,
+// or -1 if the header was missing or unparseable.
+func (s *BatchStats) Record(drain string, expected, parsed int, truncated bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	stats, ok := s.drains[drain]
+	if !ok {
+		stats = &drainBatchStats{}
+		s.drains[drain] = stats
+	}
+
+	stats.Batches++
+	if truncated {
+		stats.Truncated++
+	}
+	if expected >= 0 && expected != parsed {
+		stats.Mismatched++
+		if expected > parsed {
+			stats.LostLines += expected - parsed
+		}
+	}
+}
+
+// Adds the totals across drains to ctx and resets them. Metric names leave
+// the drain tokens out, the drain is logged along with each broken batch.
+func (s *BatchStats) Sample(ctx slog.Context) {
+	s.Lock()
+	defer s.Unlock()
+
+	var total drainBatchStats
+	drains := 0
+	for _, stats := range s.drains {
+		total.Batches += stats.Batches
+		if stats.Truncated == 0 && stats.Mismatched == 0 {
+			continue
+		}
+		drains++
+		total.Truncated += stats.Truncated
+		total.Mismatched += stats.Mismatched
+		total.LostLines += stats.LostLines
+	}
+	if drains > 0 {
+		ctx.Sample("batches.total", total.Batches)
+		ctx.Sample("batches.truncated", total.Truncated)
+		ctx.Sample("batches.mismatched", total.Mismatched)
+		ctx.Sample("batches.lost_lines", total.LostLines)
+		ctx.Sample("batches.drains.affected", drains)
+	}
+	s.drains = make(map[string]*drainBatchStats)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/heroku/slog"
+)
+
+func TestBatchStats(t *testing.T) {
+	s := NewBatchStats()
+
+	s.Record("d.1", 10, 10, false)
+	s.Record("d.1", 10, 7, true)
+	s.Record("d.1", -1, 3, false)
+	s.Record("d.1", 2, 3, false)
+	// Nothing wrong with this one's batches
+	s.Record("d.2", 5, 5, false)
+	s.Record("d.2", -1, 5, false)
+
+	ctx := slog.Context{}
+	s.Sample(ctx)
+
+	expected := map[string]int{
+		"sample#batches.total":           6,
+		"sample#batches.truncated":       1,
+		"sample#batches.mismatched":      2,
+		"sample#batches.lost_lines":      3,
+		"sample#batches.drains.affected": 1,
+	}
+	if len(ctx) != len(expected) {
+		t.Errorf("Expected only the totals to be sampled, got %v", ctx)
+	}
+	for key, value := range expected {
+		if ctx[key] != value {
+			t.Errorf("Expected %s to be %d, got %v", key, value, ctx[key])
+		}
+	}
+
+	ctx = slog.Context{}
+	s.Sample(ctx)
+	if len(ctx) != 0 {
+		t.Errorf("Expected the totals to be reset after sampling, got %v", ctx)
+	}
+}
@@ -11,8 +11,35 @@ type ChanGroup struct {
 	DynoMemMsgs  chan *dynoMemMsg
 	DynoLoadMsgs chan *dynoLoadMsg
 
+	DynoLifecycleMsgs chan *dynoLifecycleMsg
+
 	RouterMsgs   chan *routerMsg
 	RouterErrors chan *routerError
+
+	LogplexErrors chan *logplexError
+
+	ReleaseMsgs   chan *releaseMsg
+	DeployReports chan *deployReport
+
+	ProcessTypeRollups chan *processTypeRollup
+	DynoOutliers       chan *dynoOutlier
+
+	CorrelatedRequests chan *correlatedRequest
+	AppRequests        chan *appRequestMsg
+	SidekiqJobs        chan *sidekiqJobMsg
+
+	PostgresMetrics chan *postgresMetricsMsg
+	RedisMetrics    chan *redisMetricsMsg
+
+	SlowQueryRollups   chan *slowQueryRollup
+	ExceptionGroups    chan *exceptionGroup
+	RuleMatches        chan *ruleMatch
+	LogLevelRollups    chan *logLevelRollup
+	EndpointRollups    chan *endpointRollup
+	SloStatuses        chan *sloStatus
+	ApdexRollups       chan *apdexRollup
+	AnomalyEvents      chan *anomalyEvent
+	ConcurrencyRollups chan *concurrencyRollup
 }
 
 func NewChanGroup(name string, chanCap int) *ChanGroup {
@@ -20,8 +47,28 @@ func NewChanGroup(name string, chanCap int) *ChanGroup {
 	group.DynoErrors = make(chan *dynoError, chanCap)
 	group.DynoMemMsgs = make(chan *dynoMemMsg, chanCap)
 	group.DynoLoadMsgs = make(chan *dynoLoadMsg, chanCap)
+	group.DynoLifecycleMsgs = make(chan *dynoLifecycleMsg, chanCap)
 	group.RouterMsgs = make(chan *routerMsg, chanCap)
 	group.RouterErrors = make(chan *routerError, chanCap)
+	group.LogplexErrors = make(chan *logplexError, chanCap)
+	group.ReleaseMsgs = make(chan *releaseMsg, chanCap)
+	group.DeployReports = make(chan *deployReport, chanCap)
+	group.ProcessTypeRollups = make(chan *processTypeRollup, chanCap)
+	group.DynoOutliers = make(chan *dynoOutlier, chanCap)
+	group.CorrelatedRequests = make(chan *correlatedRequest, chanCap)
+	group.AppRequests = make(chan *appRequestMsg, chanCap)
+	group.SidekiqJobs = make(chan *sidekiqJobMsg, chanCap)
+	group.PostgresMetrics = make(chan *postgresMetricsMsg, chanCap)
+	group.RedisMetrics = make(chan *redisMetricsMsg, chanCap)
+	group.SlowQueryRollups = make(chan *slowQueryRollup, chanCap)
+	group.ExceptionGroups = make(chan *exceptionGroup, chanCap)
+	group.RuleMatches = make(chan *ruleMatch, chanCap)
+	group.LogLevelRollups = make(chan *logLevelRollup, chanCap)
+	group.EndpointRollups = make(chan *endpointRollup, chanCap)
+	group.SloStatuses = make(chan *sloStatus, chanCap)
+	group.ApdexRollups = make(chan *apdexRollup, chanCap)
+	group.AnomalyEvents = make(chan *anomalyEvent, chanCap)
+	group.ConcurrencyRollups = make(chan *concurrencyRollup, chanCap)
 
 	return group
 }
@@ -31,6 +78,26 @@ func (group *ChanGroup) Sample(ctx slog.Context) {
 	ctx.Sample("points.DynoErrors.pending", len(group.DynoErrors))
 	ctx.Sample("points.DynoMemMsgs.pending", len(group.DynoMemMsgs))
 	ctx.Sample("points.DynoLoadMsgs.pending", len(group.DynoLoadMsgs))
+	ctx.Sample("points.DynoLifecycleMsgs.pending", len(group.DynoLifecycleMsgs))
 	ctx.Sample("points.RouterMsgs.pending", len(group.RouterMsgs))
 	ctx.Sample("points.RouterErrors.pending", len(group.RouterErrors))
+	ctx.Sample("points.LogplexErrors.pending", len(group.LogplexErrors))
+	ctx.Sample("points.ReleaseMsgs.pending", len(group.ReleaseMsgs))
+	ctx.Sample("points.DeployReports.pending", len(group.DeployReports))
+	ctx.Sample("points.ProcessTypeRollups.pending", len(group.ProcessTypeRollups))
+	ctx.Sample("points.DynoOutliers.pending", len(group.DynoOutliers))
+	ctx.Sample("points.CorrelatedRequests.pending", len(group.CorrelatedRequests))
+	ctx.Sample("points.AppRequests.pending", len(group.AppRequests))
+	ctx.Sample("points.SidekiqJobs.pending", len(group.SidekiqJobs))
+	ctx.Sample("points.PostgresMetrics.pending", len(group.PostgresMetrics))
+	ctx.Sample("points.RedisMetrics.pending", len(group.RedisMetrics))
+	ctx.Sample("points.SlowQueryRollups.pending", len(group.SlowQueryRollups))
+	ctx.Sample("points.ExceptionGroups.pending", len(group.ExceptionGroups))
+	ctx.Sample("points.RuleMatches.pending", len(group.RuleMatches))
+	ctx.Sample("points.LogLevelRollups.pending", len(group.LogLevelRollups))
+	ctx.Sample("points.EndpointRollups.pending", len(group.EndpointRollups))
+	ctx.Sample("points.SloStatuses.pending", len(group.SloStatuses))
+	ctx.Sample("points.ApdexRollups.pending", len(group.ApdexRollups))
+	ctx.Sample("points.AnomalyEvents.pending", len(group.AnomalyEvents))
+	ctx.Sample("points.ConcurrencyRollups.pending", len(group.ConcurrencyRollups))
 }
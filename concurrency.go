@@ -0,0 +1,193 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Dynos not heard from for this long no longer count towards the capacity of
+// their process type
+const concurrencyDynoTTL = 10 * time.Minute
+
+// Requests in flight on a dyno, or across a process type, over one
+// aggregation window. By Little's law the average number of requests in
+// flight is the throughput times the average time each one spends on the
+// dyno.
+type concurrencyRollup struct {
+	Dyno        string // "" for the whole process type
+	ProcessType string
+	Dynos       int
+	Requests    int
+	Throughput  float64 // requests per second
+	ServiceAvg  float64 // ms
+	Concurrency float64 // requests in flight
+	Workers     int     // requests the dynos can serve at once, 0 if unknown
+	Saturation  float64 // Concurrency over Workers
+
+	timestamp   int64
+	sourceDrain string
+}
+
+type concurrencyWindow struct {
+	requests   int
+	serviceSum float64
+}
+
+// ConcurrencyEstimator estimates how many requests dynos are working on at
+// once from their throughput and service times.
+type ConcurrencyEstimator struct {
+	sync.Mutex
+	windowStart time.Time
+	drains      map[string]map[string]*concurrencyWindow
+	seen        map[string]map[string]time.Time // end of the last window each dyno was heard from in
+}
+
+func NewConcurrencyEstimator() *ConcurrencyEstimator {
+	return &ConcurrencyEstimator{
+		windowStart: time.Now(),
+		drains:      make(map[string]map[string]*concurrencyWindow),
+		seen:        make(map[string]map[string]time.Time),
+	}
+}
+
+// Returns the window of dyno. Must be called with the lock held.
+func (e *ConcurrencyEstimator) window(drain, dyno string) *concurrencyWindow {
+	dynos, ok := e.drains[drain]
+	if !ok {
+		dynos = make(map[string]*concurrencyWindow)
+		e.drains[drain] = dynos
+	}
+	w, ok := dynos[dyno]
+	if !ok {
+		w = &concurrencyWindow{}
+		dynos[dyno] = w
+	}
+	return w
+}
+
+// Records a request served by dyno. service is the time it spent on the dyno
+// in ms.
+func (e *ConcurrencyEstimator) ObserveRouter(drain, dyno string, service float64) {
+	if dyno == "" {
+		return
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	w := e.window(drain, dyno)
+	w.requests++
+	w.serviceSum += service
+}
+
+// Records that dyno is running, e.g. from its runtime metrics, so that it
+// counts towards the capacity of its process type while it serves nothing.
+func (e *ConcurrencyEstimator) ObserveDyno(drain, dyno string) {
+	if dyno == "" {
+		return
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.window(drain, dyno)
+}
+
+// Returns the rollups per dyno and per process type for the window ending at
+// now and starts a new window.
+func (e *ConcurrencyEstimator) Flush(now time.Time) []*concurrencyRollup {
+	e.Lock()
+	drains := e.drains
+	seconds := now.Sub(e.windowStart).Seconds()
+	e.drains = make(map[string]map[string]*concurrencyWindow)
+	e.windowStart = now
+
+	// Dynos that served nothing this window still count towards the capacity
+	// of their process type
+	typeDynos := make(map[string]map[string]int)
+	for drain, dynos := range drains {
+		seen, ok := e.seen[drain]
+		if !ok {
+			seen = make(map[string]time.Time)
+			e.seen[drain] = seen
+		}
+		for dyno := range dynos {
+			seen[dyno] = now
+		}
+	}
+	for drain, seen := range e.seen {
+		counts := make(map[string]int)
+		for dyno, at := range seen {
+			if now.Sub(at) > concurrencyDynoTTL {
+				delete(seen, dyno)
+				continue
+			}
+			counts[dynoType(dyno)]++
+		}
+		if len(seen) == 0 {
+			delete(e.seen, drain)
+		}
+		typeDynos[drain] = counts
+	}
+	e.Unlock()
+
+	if seconds <= 0 {
+		seconds = 1
+	}
+	timestamp := now.UnixNano() / int64(time.Microsecond)
+
+	rollups := make([]*concurrencyRollup, 0)
+	for drain, dynos := range drains {
+		workers := tenantConfigs.For(drain).Workers
+		add := func(dyno, processType string, dynoCount int, w *concurrencyWindow) {
+			rollup := &concurrencyRollup{
+				Dyno:        dyno,
+				ProcessType: processType,
+				Dynos:       dynoCount,
+				Requests:    w.requests,
+				Throughput:  float64(w.requests) / seconds,
+				ServiceAvg:  ratio(w.serviceSum, float64(w.requests)),
+				Workers:     workers[processType] * dynoCount,
+				timestamp:   timestamp,
+				sourceDrain: drain,
+			}
+			rollup.Concurrency = rollup.Throughput * rollup.ServiceAvg / 1000
+			rollup.Saturation = ratio(rollup.Concurrency, float64(rollup.Workers))
+			rollups = append(rollups, rollup)
+		}
+
+		types := make(map[string]*concurrencyWindow)
+		for dyno, w := range dynos {
+			// Only heard from through its runtime metrics
+			if w.requests == 0 {
+				continue
+			}
+			processType := dynoType(dyno)
+			add(dyno, processType, 1, w)
+
+			total, ok := types[processType]
+			if !ok {
+				total = &concurrencyWindow{}
+				types[processType] = total
+			}
+			total.requests += w.requests
+			total.serviceSum += w.serviceSum
+		}
+		for processType, w := range types {
+			add("", processType, typeDynos[drain][processType], w)
+		}
+	}
+	return rollups
+}
+
+// Dynos working on nearly as many requests as they have workers queue the
+// next ones, long before the router gives up on them with H12s.
+func saturationState(saturation float64) string {
+	switch {
+	case saturation >= 1:
+		return "critical"
+	case saturation >= 0.8:
+		return "warning"
+	}
+	return "ok"
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConcurrencyEstimator(t *testing.T) {
+	e := NewConcurrencyEstimator()
+	start := e.windowStart
+
+	// 10 req/s at 500ms on web.1 and 10 req/s at 300ms on web.2 over 60s
+	for i := 0; i < 600; i++ {
+		e.ObserveRouter("d.1", "web.1", 500)
+		e.ObserveRouter("d.1", "web.2", 300)
+	}
+
+	rollups := e.Flush(start.Add(time.Minute))
+	if len(rollups) != 3 {
+		t.Fatalf("Expected 2 dyno and 1 process type rollups, got %+v", rollups)
+	}
+	for _, rollup := range rollups {
+		var expected float64
+		switch rollup.Dyno {
+		case "web.1":
+			expected = 5
+		case "web.2":
+			expected = 3
+		case "":
+			expected = 8
+			if rollup.Dynos != 2 || rollup.ProcessType != "web" {
+				t.Errorf("Unexpected process type rollup %+v", *rollup)
+			}
+		}
+		if rollup.Concurrency < expected-0.01 || rollup.Concurrency > expected+0.01 {
+			t.Errorf("Expected %s to have %.0f requests in flight, got %+v", rollup.Dyno, expected, *rollup)
+		}
+		if rollup.Workers != 0 || rollup.Saturation != 0 {
+			t.Errorf("Expected no saturation without configured workers, got %+v", *rollup)
+		}
+	}
+
+	if saturationState(0.5) != "ok" || saturationState(0.9) != "warning" || saturationState(1.2) != "critical" {
+		t.Errorf("Unexpected saturation states")
+	}
+}
+
+func TestConcurrencyEstimatorSaturation(t *testing.T) {
+	configs, err := parseTenantConfigs([]byte(`{"d.1": {"workers": {"web": 10}}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	defer func(c TenantConfigs) { tenantConfigs = c }(tenantConfigs)
+	tenantConfigs = configs
+
+	e := NewConcurrencyEstimator()
+	start := e.windowStart
+
+	// 10 req/s on web.1 at 900ms and web.2 at 1600ms, web.3 idle
+	for i := 0; i < 600; i++ {
+		e.ObserveRouter("d.1", "web.1", 900)
+		e.ObserveRouter("d.1", "web.2", 1600)
+	}
+	e.ObserveDyno("d.1", "web.3")
+
+	expected := map[string]struct {
+		dynos, workers int
+		saturation     float64
+		state          string
+	}{
+		"web.1": {1, 10, 0.9, "warning"},
+		"web.2": {1, 10, 1.6, "critical"},
+		"":      {3, 30, 25.0 / 30, "warning"},
+	}
+	rollups := e.Flush(start.Add(time.Minute))
+	if len(rollups) != len(expected) {
+		t.Fatalf("Expected %d rollups, got %+v", len(expected), rollups)
+	}
+	for _, rollup := range rollups {
+		want := expected[rollup.Dyno]
+		if rollup.Dynos != want.dynos || rollup.Workers != want.workers {
+			t.Errorf("Expected %q to have %d dynos and %d workers, got %+v", rollup.Dyno, want.dynos, want.workers, *rollup)
+		}
+		if rollup.Saturation < want.saturation-0.01 || rollup.Saturation > want.saturation+0.01 {
+			t.Errorf("Expected %q to be %.2f saturated, got %+v", rollup.Dyno, want.saturation, *rollup)
+		}
+		if state := saturationState(rollup.Saturation); state != want.state {
+			t.Errorf("Expected %q to be %s, got %s", rollup.Dyno, want.state, state)
+		}
+	}
+
+	// web.3 is still around without being heard from this window
+	for i := 0; i < 600; i++ {
+		e.ObserveRouter("d.1", "web.1", 1500)
+		e.ObserveRouter("d.1", "web.2", 1500)
+	}
+	for _, rollup := range e.Flush(start.Add(2 * time.Minute)) {
+		if rollup.Dyno != "" {
+			continue
+		}
+		if rollup.Dynos != 3 || rollup.Saturation < 0.99 || saturationState(rollup.Saturation) != "critical" {
+			t.Errorf("Expected web to be critical across 3 dynos, got %+v", *rollup)
+		}
+	}
+
+	// Until it has been gone for a while
+	e.ObserveRouter("d.1", "web.1", 100)
+	for _, rollup := range e.Flush(start.Add(2*time.Minute + concurrencyDynoTTL + time.Second)) {
+		if rollup.Dyno == "" && (rollup.Dynos != 1 || rollup.Workers != 10) {
+			t.Errorf("Expected only web.1 to be left, got %+v", *rollup)
+		}
+	}
+}
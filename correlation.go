@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// App fields carried over onto a correlated request, at most
+const correlatedAppFields = 32
+
+// A router line joined with the app lines logged for the same request id
+type correlatedRequest struct {
+	RequestId  string            `json:"request_id"`
+	Drain      string            `json:"drain"`
+	RouterSeen bool              `json:"router_seen"`
+	Method     string            `json:"method,omitempty"`
+	Path       string            `json:"path,omitempty"`
+	Host       string            `json:"host,omitempty"`
+	Dyno       string            `json:"dyno,omitempty"`
+	Status     int               `json:"status,omitempty"`
+	Connect    float64           `json:"connect_ms,omitempty"`
+	Service    float64           `json:"service_ms,omitempty"`
+	AppLines   int               `json:"app_lines"`
+	AppFields  map[string]string `json:"app_fields"`
+	FirstSeen  time.Time         `json:"first_seen"`
+
+	timestamp   int64
+	sourceDrain string
+	release     string
+}
+
+// Request ids are only unique within a drain
+type correlationKey struct {
+	drain, requestId string
+}
+
+// RequestCorrelator holds on to router and app lines for a short while, keyed
+// by drain and request id, so they can be joined into a single enriched
+// request.
+type RequestCorrelator struct {
+	sync.Mutex
+	capacity  int
+	window    time.Duration
+	retention time.Duration
+	requests  map[correlationKey]*correlatedRequest
+	pending   []correlationKey // Not yet emitted, oldest first
+	retained  []correlationKey // Emitted, kept around for lookups, oldest first
+}
+
+func NewRequestCorrelator(capacity int, window, retention time.Duration) *RequestCorrelator {
+	return &RequestCorrelator{
+		capacity:  capacity,
+		window:    window,
+		retention: retention,
+		requests:  make(map[correlationKey]*correlatedRequest),
+	}
+}
+
+// Returns the entry for requestId of drain, making room for it if needed.
+// Must be called with the lock held.
+func (c *RequestCorrelator) request(drain, requestId string) *correlatedRequest {
+	key := correlationKey{drain, requestId}
+	if req, ok := c.requests[key]; ok {
+		return req
+	}
+
+	// Lookups can do without the oldest requests before pending ones can
+	for len(c.requests) >= c.capacity {
+		if len(c.retained) > 0 {
+			delete(c.requests, c.retained[0])
+			c.retained = c.retained[1:]
+		} else {
+			delete(c.requests, c.pending[0])
+			c.pending = c.pending[1:]
+		}
+	}
+
+	req := &correlatedRequest{
+		RequestId:   requestId,
+		Drain:       drain,
+		AppFields:   make(map[string]string),
+		FirstSeen:   time.Now(),
+		sourceDrain: drain,
+	}
+	c.requests[key] = req
+	c.pending = append(c.pending, key)
+	return req
+}
+
+func (c *RequestCorrelator) ObserveRouter(drain string, rm *routerMsg) {
+	if rm.RequestId == "" {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	req := c.request(drain, rm.RequestId)
+	req.RouterSeen = true
+	req.Method = rm.Method
+	req.Path = rm.Path
+	req.Host = rm.Host
+	req.Dyno = rm.Dyno
+	req.Status = rm.Status
+	req.Connect = rm.Connect
+	req.Service = rm.Service
+	req.timestamp = rm.timestamp
+	req.release = rm.release
+}
+
+// Records an app line logged for requestId, merging its fields into the
+// request.
+func (c *RequestCorrelator) ObserveApp(drain, requestId string, fields map[string]string, timestamp int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	req := c.request(drain, requestId)
+	req.AppLines++
+	if req.timestamp == 0 {
+		req.timestamp = timestamp
+	}
+	for k, v := range fields {
+		if _, ok := req.AppFields[k]; ok || len(req.AppFields) < correlatedAppFields {
+			req.AppFields[k] = v
+		}
+	}
+}
+
+// Returns the requests that have been around for a window and for which both
+// a router line and app lines were seen, and forgets requests past retention.
+func (c *RequestCorrelator) Flush(now time.Time) []*correlatedRequest {
+	c.Lock()
+	defer c.Unlock()
+
+	joined := make([]*correlatedRequest, 0)
+	for len(c.pending) > 0 {
+		req, ok := c.requests[c.pending[0]]
+		if ok && now.Sub(req.FirstSeen) < c.window {
+			break
+		}
+		if ok {
+			if req.RouterSeen && req.AppLines > 0 {
+				joined = append(joined, req.copy())
+			}
+			c.retained = append(c.retained, c.pending[0])
+		}
+		c.pending = c.pending[1:]
+	}
+
+	for len(c.retained) > 0 {
+		req, ok := c.requests[c.retained[0]]
+		if ok && now.Sub(req.FirstSeen) < c.retention {
+			break
+		}
+		delete(c.requests, c.retained[0])
+		c.retained = c.retained[1:]
+	}
+
+	return joined
+}
+
+// Returns a copy of the request of drain with requestId, if we still have it.
+func (c *RequestCorrelator) Lookup(drain, requestId string) (correlatedRequest, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	req, ok := c.requests[correlationKey{drain, requestId}]
+	if !ok {
+		return correlatedRequest{}, false
+	}
+	return *req.copy(), true
+}
+
+// Late app lines may still be merged into a request after it was handed out,
+// so hand out copies.
+func (req *correlatedRequest) copy() *correlatedRequest {
+	reqCopy := *req
+	reqCopy.AppFields = make(map[string]string, len(req.AppFields))
+	for k, v := range req.AppFields {
+		reqCopy.AppFields[k] = v
+	}
+	return &reqCopy
+}
+
+// Shows what we know about the request of ?drain=<token> given as
+// &id=<request id>
+func serveRequests(w http.ResponseWriter, r *http.Request) {
+	drain := r.URL.Query().Get("drain")
+	if drain == "" {
+		http.Error(w, "drain parameter is required", http.StatusBadRequest)
+		return
+	}
+	requestId := r.URL.Query().Get("id")
+	if requestId == "" {
+		http.Error(w, "id parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	req, ok := requestCorrelator.Lookup(drain, requestId)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestCorrelator(t *testing.T) {
+	c := NewRequestCorrelator(10, time.Second, time.Minute)
+	now := time.Now()
+
+	c.ObserveRouter("d.1", &routerMsg{RequestId: "abc", Method: "GET", Path: "/users/1", Status: 200, Service: 12})
+	c.ObserveApp("d.1", "abc", map[string]string{"user_id": "1"}, 1)
+	c.ObserveApp("d.1", "abc", map[string]string{"controller": "users"}, 2)
+	// Only the router saw this one
+	c.ObserveRouter("d.1", &routerMsg{RequestId: "def", Method: "GET", Path: "/"})
+
+	if joined := c.Flush(now); len(joined) != 0 {
+		t.Fatalf("Expected requests to be held for a window, got %+v", joined)
+	}
+
+	joined := c.Flush(now.Add(2 * time.Second))
+	if len(joined) != 1 {
+		t.Fatalf("Expected one joined request, got %+v", joined)
+	}
+	req := joined[0]
+	if req.RequestId != "abc" || req.Path != "/users/1" || req.AppLines != 2 {
+		t.Errorf("Unexpected request %+v", req)
+	}
+	if req.AppFields["user_id"] != "1" || req.AppFields["controller"] != "users" {
+		t.Errorf("Expected the app fields to be merged, got %+v", req.AppFields)
+	}
+
+	if _, ok := c.Lookup("d.1", "def"); !ok {
+		t.Errorf("Expected requests to be retained for lookups")
+	}
+	c.Flush(now.Add(2 * time.Minute))
+	if _, ok := c.Lookup("d.1", "abc"); ok {
+		t.Errorf("Expected requests to be forgotten past retention")
+	}
+}
+
+func TestRequestCorrelatorSeparatesDrains(t *testing.T) {
+	c := NewRequestCorrelator(10, time.Second, time.Minute)
+
+	c.ObserveRouter("d.1", &routerMsg{RequestId: "abc", Path: "/mine"})
+	c.ObserveRouter("d.2", &routerMsg{RequestId: "abc", Path: "/theirs"})
+
+	if req, ok := c.Lookup("d.1", "abc"); !ok || req.Path != "/mine" {
+		t.Errorf("Expected d.1's own request, got %+v", req)
+	}
+	if req, ok := c.Lookup("d.2", "abc"); !ok || req.Path != "/theirs" {
+		t.Errorf("Expected d.2's own request, got %+v", req)
+	}
+	if _, ok := c.Lookup("d.3", "abc"); ok {
+		t.Errorf("Expected d.3 not to see other drains' requests")
+	}
+}
+
+func TestRequestCorrelatorCapacity(t *testing.T) {
+	c := NewRequestCorrelator(2, time.Second, time.Minute)
+
+	c.ObserveRouter("d.1", &routerMsg{RequestId: "1"})
+	c.ObserveRouter("d.1", &routerMsg{RequestId: "2"})
+	c.Flush(time.Now().Add(2 * time.Second))
+	c.ObserveRouter("d.1", &routerMsg{RequestId: "3"})
+
+	if _, ok := c.Lookup("d.1", "1"); ok {
+		t.Errorf("Expected the oldest retained request to make room")
+	}
+	for _, id := range []string{"2", "3"} {
+		if _, ok := c.Lookup("d.1", id); !ok {
+			t.Errorf("Expected request %s to be kept", id)
+		}
+	}
+}
+
+func TestServeRequestsRequiresDrain(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/requests?id=abc", nil)
+	w := httptest.NewRecorder()
+	serveRequests(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a lookup without a drain to be refused, got %d", w.Code)
+	}
+}
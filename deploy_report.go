@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	VerdictPass = "pass"
+	VerdictWarn = "warn"
+	VerdictFail = "fail"
+
+	// Latency samples kept per drain and minute
+	deploySamplesPerMinute = 1000
+	// Requests needed on both sides of a deploy to judge it
+	deployMinRequests = 20
+	// Reports kept per drain
+	deployReportsPerDrain = 10
+)
+
+// One minute worth of router and memory data for a drain
+type deployBucket struct {
+	minute    int64
+	requests  int
+	errors5xx int
+	hErrors   int
+	latencies *reservoir
+	memSum    float64
+	memCount  int
+}
+
+// Summary of a window of buckets on one side of a deploy
+type deployWindowStats struct {
+	Requests     int     `json:"requests"`
+	LatencyP50   float64 `json:"latency_p50_ms"`
+	LatencyP95   float64 `json:"latency_p95_ms"`
+	LatencyP99   float64 `json:"latency_p99_ms"`
+	Error5xx     float64 `json:"error_5xx_ratio"`
+	HErrors      float64 `json:"h_error_ratio"`
+	MemoryMB     float64 `json:"memory_mb"`
+	MemorySample int     `json:"memory_samples"`
+}
+
+type deployReport struct {
+	Drain    string             `json:"drain"`
+	Release  string             `json:"release"`
+	At       time.Time          `json:"released_at"`
+	Window   float64            `json:"window_seconds"`
+	Complete bool               `json:"complete"`
+	Verdict  string             `json:"verdict,omitempty"`
+	Reasons  []string           `json:"reasons,omitempty"`
+	Before   deployWindowStats  `json:"before"`
+	After    *deployWindowStats `json:"after,omitempty"`
+
+	minute int64
+	due    time.Time
+}
+
+type drainDeploys struct {
+	buckets []*deployBucket // Oldest first
+	reports []*deployReport // Newest last
+}
+
+// DeployWatcher keeps a short history of router latency, errors and dyno
+// memory per drain, and compares the window before a release with the window
+// after it.
+type DeployWatcher struct {
+	sync.Mutex
+	window time.Duration
+	drains map[string]*drainDeploys
+}
+
+func NewDeployWatcher(window time.Duration) *DeployWatcher {
+	return &DeployWatcher{
+		window: window,
+		drains: make(map[string]*drainDeploys),
+	}
+}
+
+func (w *DeployWatcher) windowMinutes() int64 {
+	minutes := int64(w.window / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	return minutes
+}
+
+// Returns the bucket for the minute of timestamp (in microseconds), dropping
+// buckets that have fallen out of the history. Must be called with the lock
+// held.
+func (w *DeployWatcher) bucket(drain string, timestamp int64) *deployBucket {
+	d, ok := w.drains[drain]
+	if !ok {
+		d = &drainDeploys{}
+		w.drains[drain] = d
+	}
+
+	minute := timestamp / int64(time.Minute/time.Microsecond)
+	for _, b := range d.buckets {
+		if b.minute == minute {
+			return b
+		}
+	}
+
+	b := &deployBucket{minute: minute, latencies: newReservoir(deploySamplesPerMinute)}
+	d.buckets = append(d.buckets, b)
+
+	// Keep a bit more than a window so the after side is still around when
+	// the report comes due
+	keep := d.buckets[:0]
+	for _, b := range d.buckets {
+		if b.minute > minute-2*w.windowMinutes() {
+			keep = append(keep, b)
+		}
+	}
+	d.buckets = keep
+	return b
+}
+
+// Records a router line. latency is connect + service time in ms.
+func (w *DeployWatcher) ObserveRouter(drain string, timestamp int64, latency float64, status int, hError bool) {
+	w.Lock()
+	defer w.Unlock()
+
+	b := w.bucket(drain, timestamp)
+	b.requests++
+	// H codes come with a 503 status, count them as router errors only
+	switch {
+	case hError:
+		b.hErrors++
+	case status >= 500:
+		b.errors5xx++
+	}
+	b.latencies.Add(latency)
+}
+
+// Records a dyno memory sample in MB.
+func (w *DeployWatcher) ObserveMemory(drain string, timestamp int64, memory float64) {
+	w.Lock()
+	defer w.Unlock()
+
+	b := w.bucket(drain, timestamp)
+	b.memSum += memory
+	b.memCount++
+}
+
+// Starts a report for release, comparing the window before timestamp with
+// the window after it.
+func (w *DeployWatcher) Release(drain, release string, timestamp int64) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.bucket(drain, timestamp)
+	d := w.drains[drain]
+
+	minute := timestamp / int64(time.Minute/time.Microsecond)
+	at := time.Unix(0, timestamp*int64(time.Microsecond))
+	report := &deployReport{
+		Drain:   drain,
+		Release: release,
+		At:      at,
+		Window:  w.window.Seconds(),
+		Before:  summarizeDeployBuckets(d.buckets, minute-w.windowMinutes(), minute),
+		minute:  minute,
+		due:     at.Add(w.window + time.Minute),
+	}
+
+	d.reports = append(d.reports, report)
+	if len(d.reports) > deployReportsPerDrain {
+		d.reports = d.reports[len(d.reports)-deployReportsPerDrain:]
+	}
+}
+
+// Completes the reports whose after window has passed and returns them.
+func (w *DeployWatcher) Due(now time.Time) []*deployReport {
+	w.Lock()
+	defer w.Unlock()
+
+	due := make([]*deployReport, 0)
+	for _, d := range w.drains {
+		for _, report := range d.reports {
+			if report.Complete || now.Before(report.due) {
+				continue
+			}
+			after := summarizeDeployBuckets(d.buckets, report.minute, report.minute+w.windowMinutes())
+			report.After = &after
+			report.Verdict, report.Reasons = deployVerdict(report.Before, after)
+			report.Complete = true
+
+			reportCopy := *report
+			due = append(due, &reportCopy)
+		}
+	}
+	return due
+}
+
+// Returns copies of the reports for drain, newest first, optionally limited
+// to a single release.
+func (w *DeployWatcher) Reports(drain, release string) []deployReport {
+	w.Lock()
+	defer w.Unlock()
+
+	reports := make([]deployReport, 0)
+	d, ok := w.drains[drain]
+	if !ok {
+		return reports
+	}
+	for i := len(d.reports) - 1; i >= 0; i-- {
+		if release == "" || d.reports[i].Release == release {
+			reports = append(reports, *d.reports[i])
+		}
+	}
+	return reports
+}
+
+// Summarizes the buckets with from <= minute < to.
+func summarizeDeployBuckets(buckets []*deployBucket, from, to int64) deployWindowStats {
+	stats := deployWindowStats{}
+	latencies := make([]float64, 0)
+	errors5xx, hErrors := 0, 0
+	memSum := 0.0
+
+	for _, b := range buckets {
+		if b.minute < from || b.minute >= to {
+			continue
+		}
+		stats.Requests += b.requests
+		errors5xx += b.errors5xx
+		hErrors += b.hErrors
+		latencies = append(latencies, b.latencies.values...)
+		memSum += b.memSum
+		stats.MemorySample += b.memCount
+	}
+
+	stats.LatencyP50 = percentile(latencies, 0.50)
+	stats.LatencyP95 = percentile(latencies, 0.95)
+	stats.LatencyP99 = percentile(latencies, 0.99)
+	stats.Error5xx = ratio(float64(errors5xx), float64(stats.Requests))
+	stats.HErrors = ratio(float64(hErrors), float64(stats.Requests))
+	stats.MemoryMB = ratio(memSum, float64(stats.MemorySample))
+	return stats
+}
+
+// Judges a deploy by how much worse things got after it.
+func deployVerdict(before, after deployWindowStats) (string, []string) {
+	if before.Requests < deployMinRequests || after.Requests < deployMinRequests {
+		return VerdictWarn, []string{"not enough traffic to compare"}
+	}
+
+	verdict := VerdictPass
+	reasons := make([]string, 0)
+	worse := func(v string, reason string) {
+		if v == VerdictFail || verdict == VerdictPass {
+			verdict = v
+		}
+		reasons = append(reasons, reason)
+	}
+
+	// A p95 of 0 before would make any latency after look fine, so compare
+	// against at least 1ms
+	latency := after.LatencyP95 / math.Max(before.LatencyP95, 1)
+	switch {
+	case latency >= 1.5:
+		worse(VerdictFail, fmt.Sprintf("p95 latency up %.0f%%", (latency-1)*100))
+	case latency >= 1.2:
+		worse(VerdictWarn, fmt.Sprintf("p95 latency up %.0f%%", (latency-1)*100))
+	}
+
+	errors := (after.Error5xx + after.HErrors) - (before.Error5xx + before.HErrors)
+	switch {
+	case errors >= 0.05:
+		worse(VerdictFail, fmt.Sprintf("error ratio up %.1f points", errors*100))
+	case errors >= 0.01:
+		worse(VerdictWarn, fmt.Sprintf("error ratio up %.1f points", errors*100))
+	}
+
+	if before.MemorySample > 0 && after.MemorySample > 0 {
+		if memory := ratio(after.MemoryMB, before.MemoryMB); memory >= 1.3 {
+			worse(VerdictWarn, fmt.Sprintf("memory up %.0f%%", (memory-1)*100))
+		}
+	}
+
+	return verdict, reasons
+}
+
+// Riemann state for a verdict
+func verdictState(verdict string) string {
+	switch verdict {
+	case VerdictFail:
+		return "critical"
+	case VerdictWarn:
+		return "warning"
+	}
+	return "ok"
+}
+
+// Lists deploy regression reports for ?drain=<token>, optionally only the one
+// for &release=<version>, newest first.
+func serveDeploys(w http.ResponseWriter, r *http.Request) {
+	drain := r.URL.Query().Get("drain")
+	if drain == "" {
+		http.Error(w, "drain parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deployWatcher.Reports(drain, r.URL.Query().Get("release")))
+}
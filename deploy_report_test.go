@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeployReport(t *testing.T) {
+	watcher := NewDeployWatcher(5 * time.Minute)
+	minute := int64(time.Minute / time.Microsecond)
+	release := 100 * minute
+
+	// 100ms before the release, 200ms and some errors after it
+	for i := int64(1); i <= 5; i++ {
+		for j := 0; j < 10; j++ {
+			watcher.ObserveRouter("d.1", release-i*minute, 100, 200, false)
+			watcher.ObserveRouter("d.1", release+(i-1)*minute, 200, 200, false)
+		}
+		watcher.ObserveRouter("d.1", release+(i-1)*minute, 30000, 503, true)
+	}
+	watcher.Release("d.1", "v2", release)
+
+	releasedAt := time.Unix(0, release*int64(time.Microsecond))
+	if due := watcher.Due(releasedAt.Add(time.Minute)); len(due) != 0 {
+		t.Fatalf("Report should not be due before the window has passed, got %d", len(due))
+	}
+
+	due := watcher.Due(releasedAt.Add(10 * time.Minute))
+	if len(due) != 1 {
+		t.Fatalf("Expected one report to be due, got %d", len(due))
+	}
+
+	report := due[0]
+	if report.Before.Requests != 50 || report.After.Requests != 55 {
+		t.Errorf("Expected 50 requests before and 55 after, got %d and %d", report.Before.Requests, report.After.Requests)
+	}
+	if report.Before.LatencyP50 != 100 || report.After.LatencyP50 != 200 {
+		t.Errorf("Expected p50 of 100ms before and 200ms after, got %.0f and %.0f", report.Before.LatencyP50, report.After.LatencyP50)
+	}
+	// The H12s count as router errors, not as 5xx as well
+	if report.After.Error5xx != 0 || report.After.HErrors != 5.0/55 {
+		t.Errorf("Expected no 5xx and 5 of 55 H errors after, got %.3f and %.3f", report.After.Error5xx, report.After.HErrors)
+	}
+	if report.Verdict != VerdictFail {
+		t.Errorf("Expected verdict %s, got %s (%v)", VerdictFail, report.Verdict, report.Reasons)
+	}
+
+	if reports := watcher.Reports("d.1", "v2"); len(reports) != 1 || !reports[0].Complete {
+		t.Errorf("Expected the completed report to be listed for v2, got %v", reports)
+	}
+}
+
+func TestDeployVerdictZeroLatencyBaseline(t *testing.T) {
+	before := deployWindowStats{Requests: 100, LatencyP95: 0}
+	after := deployWindowStats{Requests: 100, LatencyP95: 50}
+
+	if verdict, reasons := deployVerdict(before, after); verdict != VerdictFail {
+		t.Errorf("Expected verdict %s going from 0ms to 50ms, got %s (%v)", VerdictFail, verdict, reasons)
+	}
+	if verdict, reasons := deployVerdict(before, before); verdict != VerdictPass {
+		t.Errorf("Expected verdict %s when nothing changed, got %s (%v)", VerdictPass, verdict, reasons)
+	}
+}
+
+func TestDeployVerdictCountsRouterErrorsOnce(t *testing.T) {
+	watcher := NewDeployWatcher(time.Minute)
+	for i := 0; i < 99; i++ {
+		watcher.ObserveRouter("d.1", 0, 10, 200, false)
+	}
+	watcher.ObserveRouter("d.1", 0, 30000, 503, true)
+
+	stats := summarizeDeployBuckets(watcher.drains["d.1"].buckets, 0, 1)
+	if stats.Error5xx+stats.HErrors != 0.01 {
+		t.Errorf("Expected an error ratio of 0.01, got %.3f", stats.Error5xx+stats.HErrors)
+	}
+}
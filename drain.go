@@ -7,6 +7,7 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -59,6 +60,19 @@ func checkAuth(r *http.Request) error {
 	return nil
 }
 
+// Wraps handlers that serve tenant data, so reading it takes the USER and
+// PASSWORD credentials
+func requireAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checkAuth(r); err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="lumbermill"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
 // Dyno's are generally reported as "<type>.<#>"
 // Extract the <type> and return it
 func dynoType(what string) string {
@@ -66,6 +80,16 @@ func dynoType(what string) string {
 	return s[0]
 }
 
+// Logplex stamps every line, app output included, in this format. Returns
+// microseconds since the epoch.
+func parseLogplexTime(t []byte) (int64, error) {
+	parsed, err := time.Parse("2006-01-02T15:04:05.000000+00:00", string(t))
+	if err != nil {
+		return 0, err
+	}
+	return parsed.UnixNano() / int64(time.Microsecond), nil
+}
+
 // "Parse tree" from hell
 func serveDrain(w http.ResponseWriter, r *http.Request) {
 	ctx := slog.Context{}
@@ -78,7 +102,8 @@ func serveDrain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := r.Header.Get("Logplex-Drain-Token")
+	token := r.Header.Get("Logplex-Drain-Token")
+	id := token
 
 	/*if id == "" {
 		if err := checkAuth(r); err != nil {
@@ -88,12 +113,34 @@ func serveDrain(w http.ResponseWriter, r *http.Request) {
 		}
 	}*/
 
+	// Logplex re-sends frames it timed out on, so acknowledge those we have
+	// already processed without counting them again
+	frameId := r.Header.Get("Logplex-Frame-Id")
+	if frameId != "" {
+		if frameDeduper.Seen(token, frameId, time.Now()) {
+			ctx.Count("batch.duplicate", 1)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
 	ctx.Count("batch", 1)
 
+	expectedLines := -1
+	if msgCount := r.Header.Get("Logplex-Msg-Count"); msgCount != "" {
+		if n, err := strconv.Atoi(msgCount); err == nil {
+			expectedLines = n
+		} else {
+			ctx.Count("errors.batch.msg_count.invalid", 1)
+		}
+	}
+
 	parseStart := time.Now()
 	lp := lpx.NewReader(bufio.NewReader(r.Body))
 
+	lines := 0
 	for lp.Next() {
+		lines++
 		ctx.Count("lines.total", 1)
 		header := lp.Header()
 
@@ -111,16 +158,16 @@ func serveDrain(w http.ResponseWriter, r *http.Request) {
 		}
 
 		chanGroup := hashRing.Get(id)
+		release := releaseTracker.Current(id)
 
 		msg := lp.Bytes()
 		switch {
 		case bytes.Equal(header.Name, Heroku), bytes.HasPrefix(header.Name, TokenPrefix):
-			t, e := time.Parse("2006-01-02T15:04:05.000000+00:00", string(lp.Header().Time))
+			timestamp, e := parseLogplexTime(lp.Header().Time)
 			if e != nil {
 				log.Printf("Error Parsing Time(%s): %q\n", string(lp.Header().Time), e)
 				continue
 			}
-			timestamp := t.UnixNano() / int64(time.Microsecond)
 
 			pid := string(header.Procid)
 			switch pid {
@@ -130,29 +177,67 @@ func serveDrain(w http.ResponseWriter, r *http.Request) {
 				// router logs with a H error code in them
 				case bytes.Contains(msg, keyCodeH):
 					ctx.Count("lines.router.error", 1)
-					re := routerError{timestamp: timestamp, sourceDrain: id}
+					re := routerError{timestamp: timestamp, sourceDrain: id, release: release}
 					err := logfmt.Unmarshal(msg, &re)
 					if err != nil {
 						log.Printf("logfmt unmarshal error: %s\n", err)
 						continue
 					}
+					re.Route = routeNormalizer.Route(id, re.Path)
+					endpointAggregator.ObserveRouter(id, re.Host, re.Method, re.Route, re.Connect+re.Service, true)
+					apdexAggregator.ObserveRouter(id, re.Host, re.Route, re.Connect+re.Service, true)
+					anomalyDetector.ObserveRouter(id, re.Host, re.Connect+re.Service, true)
+					concurrencyEstimator.ObserveRouter(id, re.Dyno, re.Service)
+					sloTracker.ObserveRouter(id, re.Host, re.Method, re.Path, re.Connect+re.Service, re.Status, true, time.Unix(0, timestamp*int64(time.Microsecond)))
+					deployWatcher.ObserveRouter(id, timestamp, re.Connect+re.Service, re.Status, true)
+					outlierDetector.ObserveRouter(id, re.Dyno, re.Connect+re.Service, true)
 					chanGroup.RouterErrors <- &re
 
 				// likely a standard router log
 				default:
 					ctx.Count("lines.router", 1)
-					rm := routerMsg{timestamp: timestamp, sourceDrain: id}
+					rm := routerMsg{timestamp: timestamp, sourceDrain: id, release: release}
 					err := logfmt.Unmarshal(msg, &rm)
 					if err != nil {
 						log.Printf("logfmt unmarshal error: %s\n", err)
 						continue
 					}
+					rm.Route = routeNormalizer.Route(id, rm.Path)
+					endpointAggregator.ObserveRouter(id, rm.Host, rm.Method, rm.Route, rm.Connect+rm.Service, rm.Status >= 500)
+					apdexAggregator.ObserveRouter(id, rm.Host, rm.Route, rm.Connect+rm.Service, rm.Status >= 500)
+					anomalyDetector.ObserveRouter(id, rm.Host, rm.Connect+rm.Service, rm.Status >= 500)
+					concurrencyEstimator.ObserveRouter(id, rm.Dyno, rm.Service)
+					sloTracker.ObserveRouter(id, rm.Host, rm.Method, rm.Path, rm.Connect+rm.Service, rm.Status, false, time.Unix(0, timestamp*int64(time.Microsecond)))
+					deployWatcher.ObserveRouter(id, timestamp, rm.Connect+rm.Service, rm.Status, false)
+					processTypeAggregator.ObserveRouter(id, rm.Dyno, rm.Connect+rm.Service)
+					if rm.Protocol != "" {
+						ctx.Count("lines.router.protocol."+rm.Protocol, 1)
+					}
+					if rm.TlsVersion != "" {
+						ctx.Count("lines.router.tls."+rm.TlsVersion, 1)
+					}
+					requestCorrelator.ObserveRouter(id, &rm)
+					outlierDetector.ObserveRouter(id, rm.Dyno, rm.Connect+rm.Service, rm.Status >= 500)
 					chanGroup.RouterMsgs <- &rm
 				}
 
 				// Non router logs, so either dynos, runtime, etc
 			default:
 				switch {
+				// Logplex telling us it dropped messages for this drain
+				case bytes.HasPrefix(msg, logplexErrorSentinel):
+					ctx.Count("lines.logplex.error", 1)
+					le, err := parseBytesToLogplexError(msg)
+					if err != nil {
+						log.Printf("Unable to parse logplex error message: %q\n", err)
+						continue
+					}
+					le.timestamp = timestamp
+					le.sourceDrain = id
+					ctx.Count("lines.logplex.dropped", le.Dropped)
+
+					chanGroup.LogplexErrors <- &le
+
 				// Dyno error messages
 				case bytes.HasPrefix(msg, dynoErrorSentinel):
 					ctx.Count("lines.dyno.error", 1)
@@ -162,37 +247,76 @@ func serveDrain(w http.ResponseWriter, r *http.Request) {
 					}
 					de.timestamp = timestamp
 					de.sourceDrain = id
+					de.release = release
 					de.Dyno = string(lp.Header().Procid)
 
 					chanGroup.DynoErrors <- &de
 
+				// Platform lines about dynos starting, stopping, crashing and cycling
+				case isDynoLifecycleMsg(msg):
+					ctx.Count("lines.dyno.lifecycle", 1)
+					dl, err := parseBytesToDynoLifecycleMsg(msg)
+					if err != nil {
+						log.Printf("Unable to parse dyno lifecycle message: %q\n", err)
+						continue
+					}
+					dl.timestamp = timestamp
+					dl.sourceDrain = id
+					dl.release = release
+					dl.Dyno = string(lp.Header().Procid)
+
+					dynoTracker.Observe(&dl)
+					if dl.State == DynoStateStarting {
+						memoryTrends.Reset(id, dl.Dyno)
+					}
+					if dl.Event != "" {
+						ctx.Count("dyno."+dl.Event, 1)
+					}
+					if dl.BootTime > 0 {
+						ctx.Measure("dyno.boot."+dynoType(dl.Dyno), dl.BootTime)
+					}
+
+					chanGroup.DynoLifecycleMsgs <- &dl
+
 				// Dyno log-runtime-metrics memory messages
 				case bytes.Contains(msg, dynoMemMsgSentinel):
 					ctx.Count("lines.dyno.mem", 1)
-					dm := dynoMemMsg{timestamp: timestamp, sourceDrain: id}
+					dm := dynoMemMsg{timestamp: timestamp, sourceDrain: id, release: release}
 					err := logfmt.Unmarshal(msg, &dm)
 					if err != nil {
 						log.Printf("logfmt unmarshal error: %s\n", err)
 						continue
 					}
 
+					deployWatcher.ObserveMemory(id, timestamp, dm.MemoryTotal)
+					processTypeAggregator.ObserveMemory(id, dm.Source, dm.MemoryTotal)
+					outlierDetector.ObserveMemory(id, dm.Source, dm.MemoryTotal)
+					memoryTrends.Observe(&dm)
+					concurrencyEstimator.ObserveDyno(id, dm.Source)
 					chanGroup.DynoMemMsgs <- &dm
 
 				// Dyno log-runtime-metrics load messages
 				case bytes.Contains(msg, dynoLoadMsgSentinel):
 					ctx.Count("lines.dyno.load", 1)
-					dl := dynoLoadMsg{timestamp: timestamp, sourceDrain: id}
+					dl := dynoLoadMsg{timestamp: timestamp, sourceDrain: id, release: release}
 					err := logfmt.Unmarshal(msg, &dl)
 					if err != nil {
 						log.Printf("logfmt unmarshal error: %s\n", err)
 						continue
 					}
 
+					processTypeAggregator.ObserveLoad(id, dl.Source, dl.LoadAvg1Min)
+					outlierDetector.ObserveLoad(id, dl.Source, dl.LoadAvg1Min)
 					chanGroup.DynoLoadMsgs <- &dl
 
+				// Lines the tenant wrote rules for
+				case applyRules(ctx, chanGroup, id, release, string(header.Name), pid, msg, parseAppFields(msg), timestamp):
+					ctx.Count("lines.rule", 1)
+
 				// unknown
 				default:
 					ctx.Count("lines.unknown.heroku", 1)
+					templateMiner.Observe(id, string(header.Name)+"/"+dynoType(pid), msg, time.Now())
 					if Debug {
 						log.Printf("Unknown Heroku Line - Header: PRI: %s, Time: %s, Hostname: %s, Name: %s, ProcId: %s, MsgId: %s - Body: %s",
 							header.PrivalVersion,
@@ -209,7 +333,61 @@ func serveDrain(w http.ResponseWriter, r *http.Request) {
 
 		// non heroku lines
 		default:
+			timestamp, e := parseLogplexTime(lp.Header().Time)
+			if e != nil {
+				timestamp = time.Now().UnixNano() / int64(time.Microsecond)
+			}
+
+			pid := string(header.Procid)
+			switch {
+			// Deploys and releases, reported as app[api]
+			case isReleaseMsg(pid, msg):
+				ctx.Count("lines.api.release", 1)
+				rm := parseBytesToReleaseMsg(msg)
+				rm.timestamp = timestamp
+				rm.sourceDrain = id
+
+				releaseTracker.Observe(&rm)
+				if rm.Kind == ReleaseKindRelease {
+					deployWatcher.Release(id, rm.Version, timestamp)
+				}
+
+				chanGroup.ReleaseMsgs <- &rm
+				continue
+
+			// Heroku Postgres metrics, reported as app[heroku-postgres]
+			case pid == herokuPostgresProcid && bytes.Contains(msg, keySamplePrefix):
+				ctx.Count("lines.addon.postgres", 1)
+				pm := postgresMetricsMsg{timestamp: timestamp, sourceDrain: id}
+				err := logfmt.Unmarshal(msg, &pm)
+				if err != nil {
+					log.Printf("logfmt unmarshal error: %s\n", err)
+					continue
+				}
+
+				chanGroup.PostgresMetrics <- &pm
+				continue
+
+			// Heroku Redis metrics, reported as app[heroku-redis]
+			case pid == herokuRedisProcid && bytes.Contains(msg, keySamplePrefix):
+				ctx.Count("lines.addon.redis", 1)
+				rm := redisMetricsMsg{timestamp: timestamp, sourceDrain: id}
+				err := logfmt.Unmarshal(msg, &rm)
+				if err != nil {
+					log.Printf("logfmt unmarshal error: %s\n", err)
+					continue
+				}
+
+				chanGroup.RedisMetrics <- &rm
+				continue
+			}
+
+			if handleUserLine(ctx, chanGroup, id, release, string(header.Name), pid, msg, timestamp) {
+				continue
+			}
+
 			ctx.Count("lines.unknown.user", 1)
+			templateMiner.Observe(id, string(header.Name)+"/"+dynoType(pid), msg, time.Now())
 			if Debug {
 				log.Printf("Unknown User Line - Header: PRI: %s, Time: %s, Hostname: %s, Name: %s, ProcId: %s, MsgId: %s - Body: %s",
 					header.PrivalVersion,
@@ -225,6 +403,23 @@ func serveDrain(w http.ResponseWriter, r *http.Request) {
 	}
 	ctx.MeasureSince("lines.parse.time", parseStart)
 
+	// Surface broken framing rather than silently dropping the rest of the batch
+	truncated := false
+	if err := lp.Err(); err != nil {
+		truncated = true
+		ctx.Count("errors.batch.truncated", 1)
+		log.Printf("Error reading batch from %s after %d lines: %q\n", id, lines, err)
+		// Let the retry of a frame we couldn't read in full through
+		if frameId != "" {
+			frameDeduper.Forget(token, frameId)
+		}
+	}
+	if expectedLines >= 0 && expectedLines != lines {
+		ctx.Count("errors.batch.msg_count.mismatch", 1)
+		log.Printf("Batch from %s had %d lines, Logplex-Msg-Count said %d\n", id, lines, expectedLines)
+	}
+	batchStats.Record(id, expectedLines, lines, truncated)
+
 	// If we are told to close the connection after the reply, do so.
 	select {
 	case <-connectionCloser:
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Frames syslog lines the way Logplex does
+func logplexFrames(lines ...string) []byte {
+	var b bytes.Buffer
+	for _, line := range lines {
+		fmt.Fprintf(&b, "%d %s\n", len(line)+1, line)
+	}
+	return b.Bytes()
+}
+
+// Posts lines to the drain as token and returns the group their points went
+// to.
+func postToDrain(t *testing.T, token string, lines ...string) *ChanGroup {
+	group := NewChanGroup("test", 10)
+	defer func(ring *HashRing) { hashRing = ring }(hashRing)
+	hashRing = NewHashRing(1, nil)
+	hashRing.Add(group)
+
+	r, err := http.NewRequest("POST", "/drain", bytes.NewReader(logplexFrames(lines...)))
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	r.Header.Set("Logplex-Drain-Token", token)
+	w := httptest.NewRecorder()
+	serveDrain(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Drain replied %d, expected %d", w.Code, http.StatusNoContent)
+	}
+	return group
+}
+
+func TestRequireAuth(t *testing.T) {
+	defer func(user, password string) { User, Password = user, password }(User, Password)
+	User, Password = "lumbermill", "secret"
+
+	handler := requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	testCases := map[string]int{
+		"":                                http.StatusUnauthorized,
+		"Basic bHVtYmVybWlsbDp3cm9uZw==":  http.StatusUnauthorized, // lumbermill:wrong
+		"Basic bHVtYmVybWlsbDpzZWNyZXQ=":  http.StatusOK,           // lumbermill:secret
+		"Bearer bHVtYmVybWlsbDpzZWNyZXQ=": http.StatusUnauthorized,
+	}
+	for authorization, expected := range testCases {
+		r, _ := http.NewRequest("GET", "/dynos?drain=d.1", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != expected {
+			t.Errorf("Authorization %q got %d, expected %d", authorization, w.Code, expected)
+		}
+	}
+}
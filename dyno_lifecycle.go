@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	stateChangedSentinel    = []byte("State changed from ")
+	startingProcessSentinel = []byte("Starting process with command ")
+	stoppingSentinel        = []byte("Stopping all processes with ")
+	processExitedSentinel   = []byte("Process exited with status ")
+	cyclingSentinel         = []byte("Cycling")
+
+	dynoLifecycleSentinels = [][]byte{
+		stateChangedSentinel,
+		startingProcessSentinel,
+		stoppingSentinel,
+		processExitedSentinel,
+		cyclingSentinel,
+	}
+)
+
+const (
+	DynoStateStarting = "starting"
+	DynoStateUp       = "up"
+	DynoStateStopping = "stopping"
+	DynoStateDown     = "down"
+	DynoStateCrashed  = "crashed"
+	DynoStateCycling  = "cycling"
+	DynoStateComplete = "complete" // one-off dynos whose command finished
+)
+
+// Dynos that have gone down, crashed or completed are forgotten after this
+// long, so one-off run.N and scheduler.N dynos don't pile up
+const dynoTrackerTTL = time.Hour
+
+// What the tracker made of a lifecycle line, if anything noteworthy
+const (
+	DynoEventCrash   = "crash"
+	DynoEventRestart = "restart"
+	DynoEventCycle   = "cycle"
+)
+
+// State changed from starting to up
+// Starting process with command `bundle exec puma -C config/puma.rb`
+// Stopping all processes with SIGTERM
+// Process exited with status 143
+// Cycling
+type dynoLifecycleMsg struct {
+	Dyno       string
+	State      string
+	PrevState  string
+	Command    string
+	ExitStatus int
+	Event      string
+	Message    string
+
+	// Set on the line that brings a dyno up, if we saw it start
+	BootTime time.Duration
+
+	timestamp   int64
+	sourceDrain string
+	release     string
+}
+
+func isDynoLifecycleMsg(msg []byte) bool {
+	for _, sentinel := range dynoLifecycleSentinels {
+		if bytes.HasPrefix(msg, sentinel) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseBytesToDynoLifecycleMsg(msg []byte) (dynoLifecycleMsg, error) {
+	dl := dynoLifecycleMsg{Message: string(msg)}
+	switch {
+	case bytes.HasPrefix(msg, stateChangedSentinel):
+		// "<from> to <to>"
+		parts := strings.SplitN(string(msg[len(stateChangedSentinel):]), " to ", 2)
+		if len(parts) == 2 {
+			dl.PrevState = parts[0]
+			dl.State = strings.TrimSpace(parts[1])
+		}
+	case bytes.HasPrefix(msg, startingProcessSentinel):
+		dl.State = DynoStateStarting
+		dl.Command = strings.Trim(strings.TrimSpace(string(msg[len(startingProcessSentinel):])), "`")
+	case bytes.HasPrefix(msg, stoppingSentinel):
+		dl.State = DynoStateStopping
+	case bytes.HasPrefix(msg, processExitedSentinel):
+		dl.State = DynoStateDown
+		status, err := strconv.Atoi(string(bytes.TrimSpace(msg[len(processExitedSentinel):])))
+		if err != nil {
+			return dl, err
+		}
+		dl.ExitStatus = status
+	case bytes.HasPrefix(msg, cyclingSentinel):
+		dl.State = DynoStateCycling
+	}
+	return dl, nil
+}
+
+// The last known state of a single dyno
+type dynoStatus struct {
+	Dyno           string    `json:"dyno"`
+	Type           string    `json:"type"`
+	State          string    `json:"state"`
+	Since          time.Time `json:"since"`
+	Command        string    `json:"command,omitempty"`
+	LastExitStatus int       `json:"last_exit_status"`
+	Restarts       int       `json:"restarts"`
+	Crashes        int       `json:"crashes"`
+	Cycles         int       `json:"cycles"`
+	LastBootTime   float64   `json:"last_boot_seconds,omitempty"`
+
+	// Set from when the dyno starts going down or booting until it is up, so a
+	// single restart isn't counted once per state change along the way
+	booting bool
+
+	// When the process was last started, until it comes up
+	bootStarted time.Time
+}
+
+// DynoTracker keeps a per drain registry of dynos and the state they are in,
+// as told by the platform's lifecycle lines.
+type DynoTracker struct {
+	sync.Mutex
+	drains map[string]map[string]*dynoStatus
+	swept  time.Time // when drains were last checked for finished dynos
+}
+
+func NewDynoTracker() *DynoTracker {
+	return &DynoTracker{drains: make(map[string]map[string]*dynoStatus)}
+}
+
+// Updates the registry with dl and sets dl.Event if the line amounts to a
+// crash, restart or cycle of the dyno.
+func (t *DynoTracker) Observe(dl *dynoLifecycleMsg) {
+	if dl.State == "" {
+		return
+	}
+
+	at := time.Unix(0, dl.timestamp*int64(time.Microsecond))
+
+	t.Lock()
+	defer t.Unlock()
+
+	if at.Sub(t.swept) >= dynoTrackerTTL {
+		t.sweep(at)
+	}
+
+	dynos, ok := t.drains[dl.sourceDrain]
+	if !ok {
+		dynos = make(map[string]*dynoStatus)
+		t.drains[dl.sourceDrain] = dynos
+	}
+
+	status, known := dynos[dl.Dyno]
+	if !known {
+		status = &dynoStatus{Dyno: dl.Dyno, Type: dynoType(dl.Dyno)}
+		dynos[dl.Dyno] = status
+	}
+	if dl.PrevState == "" {
+		dl.PrevState = status.State
+	}
+
+	switch dl.State {
+	case DynoStateStarting:
+		// Only "Starting process with command" carries a command, and it is
+		// where the boot clock starts
+		if dl.Command != "" {
+			status.Command = dl.Command
+			status.bootStarted = at
+		}
+		// A dyno we have seen before booting again
+		if known && !status.booting {
+			status.Restarts++
+			dl.Event = DynoEventRestart
+		}
+		status.booting = true
+	case DynoStateUp:
+		status.booting = false
+		if !status.bootStarted.IsZero() {
+			dl.BootTime = at.Sub(status.bootStarted)
+			status.LastBootTime = dl.BootTime.Seconds()
+			status.bootStarted = time.Time{}
+		}
+	case DynoStateDown:
+		status.LastExitStatus = dl.ExitStatus
+		// Exiting after we asked it to stop is expected, whatever the status
+		if dl.ExitStatus != 0 && status.State != DynoStateStopping {
+			status.Crashes++
+			dl.Event = DynoEventCrash
+		}
+	case DynoStateCrashed:
+		// Usually follows a non-zero exit we already counted
+		if status.State != DynoStateCrashed && !(status.State == DynoStateDown && status.LastExitStatus != 0) {
+			status.Crashes++
+			dl.Event = DynoEventCrash
+		}
+		status.booting = false
+	case DynoStateCycling:
+		status.Cycles++
+		status.booting = true
+		dl.Event = DynoEventCycle
+	}
+
+	status.State = dl.State
+	status.Since = at
+}
+
+// Forgets dynos that haven't come back for a ttl since they went down,
+// crashed or completed, and drains left without dynos. Expects t to be locked.
+func (t *DynoTracker) sweep(now time.Time) {
+	for drain, dynos := range t.drains {
+		for dyno, status := range dynos {
+			switch status.State {
+			case DynoStateDown, DynoStateCrashed, DynoStateComplete:
+				if now.Sub(status.Since) > dynoTrackerTTL {
+					delete(dynos, dyno)
+				}
+			}
+		}
+		if len(dynos) == 0 {
+			delete(t.drains, drain)
+		}
+	}
+	t.swept = now
+}
+
+// Returns how long dynos of processType of drain may take to boot, 0 for no
+// limit. Heroku only holds web dynos to one, the R10 boot timeout, which apps
+// can raise with --boot-timeout. Tenants can set their own per process type.
+func bootTimeout(drain, processType string) time.Duration {
+	if seconds, ok := tenantConfigs.For(drain).BootTimeouts[processType]; ok {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if processType == "web" {
+		return BootTimeout
+	}
+	return 0
+}
+
+// How close a boot came to timeout
+func bootTimeState(bootTime, timeout time.Duration) string {
+	switch {
+	case timeout <= 0:
+		return "ok"
+	case bootTime >= timeout:
+		return "critical"
+	case bootTime >= timeout*3/4:
+		return "warning"
+	}
+	return "ok"
+}
+
+// Returns a copy of the dynos known for drain, sorted by name.
+func (t *DynoTracker) Fleet(drain string) []dynoStatus {
+	t.Lock()
+	defer t.Unlock()
+
+	fleet := make([]dynoStatus, 0, len(t.drains[drain]))
+	for _, status := range t.drains[drain] {
+		fleet = append(fleet, *status)
+	}
+	sort.Sort(byDynoName(fleet))
+	return fleet
+}
+
+type byDynoName []dynoStatus
+
+func (s byDynoName) Len() int           { return len(s) }
+func (s byDynoName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byDynoName) Less(i, j int) bool { return s[i].Dyno < s[j].Dyno }
+
+// Lists the current dyno fleet of the drain given as ?drain=<token>
+func serveDynos(w http.ResponseWriter, r *http.Request) {
+	drain := r.URL.Query().Get("drain")
+	if drain == "" {
+		http.Error(w, "drain parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dynoTracker.Fleet(drain))
+}
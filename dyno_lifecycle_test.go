@@ -0,0 +1,186 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Feeds lines about web.1 to tracker a second apart and returns the events
+// they amounted to.
+func observeDynoLines(t *testing.T, tracker *DynoTracker, start time.Time, lines ...string) ([]string, []*dynoLifecycleMsg) {
+	events := make([]string, 0)
+	msgs := make([]*dynoLifecycleMsg, 0)
+	for i, line := range lines {
+		if !isDynoLifecycleMsg([]byte(line)) {
+			t.Fatalf("Expected %q to be a lifecycle line", line)
+		}
+		dl, err := parseBytesToDynoLifecycleMsg([]byte(line))
+		if err != nil {
+			t.Fatalf("Parsing %q returned error: %s", line, err)
+		}
+		dl.Dyno = "web.1"
+		dl.sourceDrain = "d.1"
+		dl.timestamp = start.Add(time.Duration(i)*time.Second).UnixNano() / int64(time.Microsecond)
+		tracker.Observe(&dl)
+		if dl.Event != "" {
+			events = append(events, dl.Event)
+		}
+		msgs = append(msgs, &dl)
+	}
+	return events, msgs
+}
+
+func TestDynoTrackerBoot(t *testing.T) {
+	tracker := NewDynoTracker()
+	events, msgs := observeDynoLines(t, tracker, time.Now(),
+		"Starting process with command `bundle exec puma -C config/puma.rb`",
+		"State changed from starting to up",
+	)
+
+	if len(events) != 0 {
+		t.Errorf("Expected a first boot to be uneventful, got %v", events)
+	}
+	if msgs[1].BootTime != time.Second {
+		t.Errorf("Expected a boot time of 1s, got %s", msgs[1].BootTime)
+	}
+	fleet := tracker.Fleet("d.1")
+	if len(fleet) != 1 || fleet[0].State != DynoStateUp || fleet[0].Command != "bundle exec puma -C config/puma.rb" {
+		t.Errorf("Unexpected fleet %+v", fleet)
+	}
+}
+
+func TestDynoTrackerRestart(t *testing.T) {
+	tracker := NewDynoTracker()
+	now := time.Now()
+	observeDynoLines(t, tracker, now, "State changed from starting to up")
+
+	events, _ := observeDynoLines(t, tracker, now.Add(time.Hour),
+		"State changed from up to starting",
+		"Stopping all processes with SIGTERM",
+		"Process exited with status 143",
+		"Starting process with command `bundle exec puma -C config/puma.rb`",
+		"State changed from starting to up",
+	)
+
+	if len(events) != 1 || events[0] != DynoEventRestart {
+		t.Errorf("Expected a single restart, got %v", events)
+	}
+	if fleet := tracker.Fleet("d.1"); fleet[0].Restarts != 1 || fleet[0].Crashes != 0 || fleet[0].LastExitStatus != 143 {
+		t.Errorf("Unexpected status %+v", fleet[0])
+	}
+}
+
+func TestDynoTrackerCrash(t *testing.T) {
+	tracker := NewDynoTracker()
+	now := time.Now()
+	observeDynoLines(t, tracker, now, "State changed from starting to up")
+
+	events, _ := observeDynoLines(t, tracker, now.Add(time.Hour),
+		"Process exited with status 1",
+		"State changed from up to crashed",
+	)
+
+	if len(events) != 1 || events[0] != DynoEventCrash {
+		t.Errorf("Expected the exit and the state change to be one crash, got %v", events)
+	}
+	if fleet := tracker.Fleet("d.1"); fleet[0].Crashes != 1 || fleet[0].State != DynoStateCrashed {
+		t.Errorf("Unexpected status %+v", fleet[0])
+	}
+}
+
+func TestDynoTrackerCycle(t *testing.T) {
+	tracker := NewDynoTracker()
+	now := time.Now()
+	observeDynoLines(t, tracker, now, "State changed from starting to up")
+
+	events, _ := observeDynoLines(t, tracker, now.Add(24*time.Hour),
+		"Cycling",
+		"State changed from up to starting",
+		"Stopping all processes with SIGTERM",
+		"Process exited with status 143",
+		"Starting process with command `bundle exec puma -C config/puma.rb`",
+		"State changed from starting to up",
+	)
+
+	if len(events) != 1 || events[0] != DynoEventCycle {
+		t.Errorf("Expected a cycle not to count as a restart too, got %v", events)
+	}
+	if fleet := tracker.Fleet("d.1"); fleet[0].Cycles != 1 || fleet[0].Restarts != 0 {
+		t.Errorf("Unexpected status %+v", fleet[0])
+	}
+}
+
+func TestBootTimeout(t *testing.T) {
+	configs, err := parseTenantConfigs([]byte(`{"d.1": {"boot_timeouts": {"web": 120, "worker": 30}}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	defer func(c TenantConfigs) { tenantConfigs = c }(tenantConfigs)
+	tenantConfigs = configs
+
+	testCases := []struct {
+		drain, processType string
+		bootTime           time.Duration
+		state              string
+	}{
+		{"d.2", "web", 50 * time.Second, "warning"},
+		{"d.2", "web", 61 * time.Second, "critical"},
+		{"d.2", "worker", 300 * time.Second, "ok"},
+		{"d.1", "web", 61 * time.Second, "ok"},
+		{"d.1", "web", 121 * time.Second, "critical"},
+		{"d.1", "worker", 25 * time.Second, "warning"},
+		{"d.1", "clock", 300 * time.Second, "ok"},
+	}
+	for _, tc := range testCases {
+		if state := bootTimeState(tc.bootTime, bootTimeout(tc.drain, tc.processType)); state != tc.state {
+			t.Errorf("Booting %s of %s in %s is %s, expected %s", tc.processType, tc.drain, tc.bootTime, state, tc.state)
+		}
+	}
+
+	if _, err := parseTenantConfigs([]byte(`{"d.1": {"boot_timeouts": {"web": 0}}}`)); err == nil {
+		t.Errorf("Expected a boot timeout of 0 to be refused")
+	}
+}
+
+func TestDrainDynoLifecycle(t *testing.T) {
+	token := "d.dyno-lifecycle-test"
+	postToDrain(t, token,
+		"<190>1 2014-07-02T16:52:38.123456+00:00 host heroku web.1 - Starting process with command `bundle exec puma -C config/puma.rb`",
+		"<190>1 2014-07-02T16:52:40.123456+00:00 host heroku web.1 - State changed from starting to up",
+	)
+
+	fleet := dynoTracker.Fleet(token)
+	if len(fleet) != 1 || fleet[0].State != DynoStateUp || fleet[0].Command != "bundle exec puma -C config/puma.rb" {
+		t.Errorf("Unexpected fleet %+v", fleet)
+	}
+}
+
+func TestDynoTrackerForgetsFinishedDynos(t *testing.T) {
+	tracker := NewDynoTracker()
+	now := time.Now()
+	observe := func(dyno, line string, at time.Time) {
+		dl, err := parseBytesToDynoLifecycleMsg([]byte(line))
+		if err != nil {
+			t.Fatalf("Parsing %q returned error: %s", line, err)
+		}
+		dl.Dyno = dyno
+		dl.sourceDrain = "d.1"
+		dl.timestamp = at.UnixNano() / int64(time.Microsecond)
+		tracker.Observe(&dl)
+	}
+
+	observe("web.1", "State changed from starting to up", now)
+	observe("run.1234", "State changed from starting to up", now)
+	observe("run.1234", "State changed from up to complete", now.Add(time.Minute))
+	observe("scheduler.5678", "Process exited with status 1", now.Add(time.Minute))
+
+	if fleet := tracker.Fleet("d.1"); len(fleet) != 3 {
+		t.Errorf("Expected finished dynos to be kept for a while, got %+v", fleet)
+	}
+
+	observe("web.2", "State changed from starting to up", now.Add(2*time.Minute+dynoTrackerTTL))
+	fleet := tracker.Fleet("d.1")
+	if len(fleet) != 2 || fleet[0].Dyno != "web.1" || fleet[1].Dyno != "web.2" {
+		t.Errorf("Expected only the running dynos to be kept, got %+v", fleet)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -30,6 +31,7 @@ type dynoError struct {
 
 	timestamp   int64
 	sourceDrain string
+	release     string
 }
 
 func parseBytesToDynoError(msg []byte) (dynoError, error) {
@@ -55,6 +57,12 @@ type dynoMemMsg struct {
 
 	timestamp   int64
 	sourceDrain string
+	release     string
+
+	// Filled in by MemoryTrends once there are enough samples
+	trendSamples int
+	growthRate   float64       // MB per second
+	timeToQuota  time.Duration // -1 if memory isn't growing
 }
 
 func (dm *dynoMemMsg) HandleLogfmt(key, val []byte) error {
@@ -88,6 +96,7 @@ type dynoLoadMsg struct {
 
 	timestamp   int64
 	sourceDrain string
+	release     string
 }
 
 func (dm *dynoLoadMsg) HandleLogfmt(key, val []byte) error {
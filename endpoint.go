@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Router latencies kept per endpoint and window
+const endpointLatencySamples = 1000
+
+// Requests to one endpoint, a method and route of a host, over one
+// aggregation window.
+type endpointRollup struct {
+	Host   string
+	Method string
+	Route  string
+
+	Requests   int
+	Errors     int // 5xx and H codes
+	LatencyAvg float64
+	LatencyP95 float64
+	LatencyMax float64
+
+	timestamp   int64
+	sourceDrain string
+}
+
+type endpointKey struct {
+	host, method, route string
+}
+
+type endpointWindow struct {
+	errors     int
+	latencies  *reservoir
+	latencySum float64
+	latencyMax float64
+}
+
+// EndpointAggregator rolls router lines up per drain and endpoint.
+type EndpointAggregator struct {
+	sync.Mutex
+	drains map[string]map[endpointKey]*endpointWindow
+}
+
+func NewEndpointAggregator() *EndpointAggregator {
+	return &EndpointAggregator{drains: make(map[string]map[endpointKey]*endpointWindow)}
+}
+
+// Records a request to route. latency is connect + service time in ms.
+func (a *EndpointAggregator) ObserveRouter(drain, host, method, route string, latency float64, isError bool) {
+	if route == "" {
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	endpoints, ok := a.drains[drain]
+	if !ok {
+		endpoints = make(map[endpointKey]*endpointWindow)
+		a.drains[drain] = endpoints
+	}
+	key := endpointKey{host, method, route}
+	w, ok := endpoints[key]
+	if !ok {
+		w = &endpointWindow{latencies: newReservoir(endpointLatencySamples)}
+		endpoints[key] = w
+	}
+
+	w.latencies.Add(latency)
+	w.latencySum += latency
+	if latency > w.latencyMax {
+		w.latencyMax = latency
+	}
+	if isError {
+		w.errors++
+	}
+}
+
+// Returns the rollups for the window ending at now and starts a new window.
+func (a *EndpointAggregator) Flush(now time.Time) []*endpointRollup {
+	a.Lock()
+	drains := a.drains
+	a.drains = make(map[string]map[endpointKey]*endpointWindow)
+	a.Unlock()
+
+	timestamp := now.UnixNano() / int64(time.Microsecond)
+	rollups := make([]*endpointRollup, 0)
+	for drain, endpoints := range drains {
+		for key, w := range endpoints {
+			rollups = append(rollups, &endpointRollup{
+				Host:        key.host,
+				Method:      key.method,
+				Route:       key.route,
+				Requests:    w.latencies.seen,
+				Errors:      w.errors,
+				LatencyAvg:  ratio(w.latencySum, float64(w.latencies.seen)),
+				LatencyP95:  percentile(w.latencies.values, 0.95),
+				LatencyMax:  w.latencyMax,
+				timestamp:   timestamp,
+				sourceDrain: drain,
+			})
+		}
+	}
+	return rollups
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Frames of a trace that go into its fingerprint
+	exceptionFingerprintFrames = 3
+	// Frames kept per trace
+	exceptionMaxFrames = 20
+	// Sample messages kept per group
+	exceptionSamples = 5
+	// Groups tracked per drain
+	exceptionGroupsPerDrain = 1000
+	// How long a trace may go without a new frame before it is considered
+	// complete
+	exceptionTraceTimeout = 10 * time.Second
+)
+
+var (
+	// ActiveRecord::RecordNotFound (Couldn't find User with 'id'=1):
+	rubyExceptionHeader = regexp.MustCompile(`^([A-Z]\w*(?:::[A-Z]\w*)*) \((.*)\):$`)
+	// TypeError: Cannot read property 'x' of undefined
+	// ActionView::Template::Error: undefined method `name' for nil:NilClass
+	namedExceptionHeader = regexp.MustCompile(`^([A-Z]\w*(?:(?:::|\.)[A-Z]\w*)*(?:Error|Exception)): (.*)$`)
+	// panic: runtime error: index out of range
+	goPanicHeader = regexp.MustCompile(`^panic: (.*)$`)
+
+	// app/models/user.rb:12:in `name'
+	rubyFrame = regexp.MustCompile(`^\s*\S+\.rb:\d+:in `)
+	//     at Object.<anonymous> (/app/index.js:1:1)
+	nodeFrame = regexp.MustCompile(`^\s+at `)
+	// goroutine 1 [running]:
+	// main.handler(0xc20800a000, 0x1)
+	// 	/app/main.go:12 +0x1d
+	goGoroutine = regexp.MustCompile(`^goroutine \d+ \[`)
+	goFuncFrame = regexp.MustCompile(`^(?:created by )?[\w./*()-]+\(.*\)$`)
+	goFileFrame = regexp.MustCompile(`^\s+\S+\.go:\d+`)
+
+	frameLineNumbers = regexp.MustCompile(`:\d+`)
+	frameOffsets     = regexp.MustCompile(` \+0x[0-9a-f]+|0x[0-9a-f]+`)
+	messageVariables = regexp.MustCompile(`\d+|'[^']*'|"[^"]*"`)
+)
+
+// A group of exceptions sharing a class and top frames
+type exceptionGroup struct {
+	Fingerprint string    `json:"fingerprint"`
+	Class       string    `json:"class"`
+	Frames      []string  `json:"frames"`
+	Count       int       `json:"count"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	Dynos       []string  `json:"dynos"`
+	Samples     []string  `json:"samples"`
+
+	timestamp   int64
+	sourceDrain string
+	release     string
+}
+
+// A trace still being read from a dyno's output
+type pendingException struct {
+	class     string
+	message   string
+	goPanic   bool
+	frames    []string
+	lastLine  time.Time
+	timestamp int64
+	release   string
+}
+
+// ExceptionDetector spots exception headers in app output, collects the
+// trace lines that follow them on the same dyno and groups the result by a
+// fingerprint of the exception class and its top frames.
+type ExceptionDetector struct {
+	sync.Mutex
+	pending map[string]*pendingException // drain + dyno
+	groups  map[string]map[string]*exceptionGroup
+}
+
+func NewExceptionDetector() *ExceptionDetector {
+	return &ExceptionDetector{
+		pending: make(map[string]*pendingException),
+		groups:  make(map[string]map[string]*exceptionGroup),
+	}
+}
+
+func isFrameLine(line []byte) bool {
+	return rubyFrame.Match(line) || nodeFrame.Match(line) ||
+		goGoroutine.Match(line) || goFuncFrame.Match(line) || goFileFrame.Match(line)
+}
+
+// Looks at a user line from dyno. Returns whether the line was part of an
+// exception, and the groups that exceptions completed by this line created.
+func (d *ExceptionDetector) Observe(drain, release, dyno string, msg []byte, timestamp int64) (bool, []*exceptionGroup) {
+	// Logplex frames end lines in a newline the patterns don't expect
+	_, line := stripLogTags(bytes.TrimRight(msg, "\r\n"))
+	key := drain + "\x00" + dyno
+
+	d.Lock()
+	defer d.Unlock()
+
+	created := make([]*exceptionGroup, 0)
+	pending, tracing := d.pending[key]
+
+	if tracing && isFrameLine(line) {
+		if len(pending.frames) < exceptionMaxFrames {
+			pending.frames = append(pending.frames, strings.TrimSpace(string(line)))
+		}
+		pending.lastLine = time.Now()
+		return true, created
+	}
+
+	// Blank and indented lines, like the template excerpt Rails prints, are
+	// still part of the trace
+	if tracing && (len(bytes.TrimSpace(line)) == 0 || line[0] == ' ' || line[0] == '\t') {
+		pending.lastLine = time.Now()
+		return true, created
+	}
+
+	// Anything else ends the trace we were reading
+	if tracing {
+		delete(d.pending, key)
+		if group := d.record(drain, dyno, pending); group != nil {
+			created = append(created, group)
+		}
+	}
+
+	exception := &pendingException{lastLine: time.Now(), timestamp: timestamp, release: release}
+	if m := rubyExceptionHeader.FindSubmatch(line); m != nil {
+		exception.class, exception.message = string(m[1]), string(m[2])
+	} else if m := namedExceptionHeader.FindSubmatch(line); m != nil {
+		exception.class, exception.message = string(m[1]), string(m[2])
+	} else if m := goPanicHeader.FindSubmatch(line); m != nil {
+		exception.class, exception.message, exception.goPanic = "panic", string(m[1]), true
+	} else {
+		return false, created
+	}
+
+	d.pending[key] = exception
+	return true, created
+}
+
+// Completes traces that haven't seen a new frame in a while and returns the
+// groups they created.
+func (d *ExceptionDetector) Flush(now time.Time) []*exceptionGroup {
+	d.Lock()
+	defer d.Unlock()
+
+	created := make([]*exceptionGroup, 0)
+	for key, pending := range d.pending {
+		if now.Sub(pending.lastLine) < exceptionTraceTimeout {
+			continue
+		}
+		delete(d.pending, key)
+
+		parts := strings.SplitN(key, "\x00", 2)
+		if group := d.record(parts[0], parts[1], pending); group != nil {
+			created = append(created, group)
+		}
+	}
+	return created
+}
+
+// Adds a completed exception to its group. Returns a copy of the group if it
+// is a new one. Must be called with the lock held.
+func (d *ExceptionDetector) record(drain, dyno string, exception *pendingException) *exceptionGroup {
+	frames := make([]string, 0, exceptionFingerprintFrames)
+	for _, frame := range exception.frames {
+		// Go traces repeat each function with its file, the functions will do
+		if exception.goPanic && (goFileFrame.MatchString(frame) || goGoroutine.MatchString(frame)) {
+			continue
+		}
+		frame = frameOffsets.ReplaceAllString(frameLineNumbers.ReplaceAllString(frame, ""), "")
+		frames = append(frames, frame)
+		if len(frames) == exceptionFingerprintFrames {
+			break
+		}
+	}
+
+	// Without frames, or for panics whose class says nothing, the message has
+	// to tell exceptions apart
+	parts := append([]string{exception.class}, frames...)
+	if len(frames) == 0 || exception.goPanic {
+		parts = append(parts, messageVariables.ReplaceAllString(exception.message, "?"))
+	}
+	fingerprint := fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(strings.Join(parts, "\n"))))
+
+	groups, ok := d.groups[drain]
+	if !ok {
+		groups = make(map[string]*exceptionGroup)
+		d.groups[drain] = groups
+	}
+
+	at := time.Unix(0, exception.timestamp*int64(time.Microsecond))
+	group, ok := groups[fingerprint]
+	isNew := !ok
+	if !ok {
+		if len(groups) >= exceptionGroupsPerDrain {
+			return nil
+		}
+		group = &exceptionGroup{
+			Fingerprint: fingerprint,
+			Class:       exception.class,
+			Frames:      frames,
+			FirstSeen:   at,
+			sourceDrain: drain,
+		}
+		groups[fingerprint] = group
+	}
+
+	group.Count++
+	group.LastSeen = at
+	group.timestamp = exception.timestamp
+	group.release = exception.release
+	if len(group.Samples) < exceptionSamples {
+		group.Samples = append(group.Samples, exception.message)
+	}
+	seenOn := false
+	for _, seen := range group.Dynos {
+		seenOn = seenOn || seen == dyno
+	}
+	if !seenOn {
+		group.Dynos = append(group.Dynos, dyno)
+	}
+
+	if !isNew {
+		return nil
+	}
+	return group.copy()
+}
+
+// Groups keep being updated after they are handed out, so hand out copies.
+func (group *exceptionGroup) copy() *exceptionGroup {
+	groupCopy := *group
+	groupCopy.Frames = append([]string(nil), group.Frames...)
+	groupCopy.Dynos = append([]string(nil), group.Dynos...)
+	groupCopy.Samples = append([]string(nil), group.Samples...)
+	return &groupCopy
+}
+
+// Returns copies of the groups of drain, most recently seen first.
+func (d *ExceptionDetector) Groups(drain string) []exceptionGroup {
+	d.Lock()
+	defer d.Unlock()
+
+	groups := make([]exceptionGroup, 0, len(d.groups[drain]))
+	for _, group := range d.groups[drain] {
+		groups = append(groups, *group.copy())
+	}
+	sort.Sort(byLastSeen(groups))
+	return groups
+}
+
+type byLastSeen []exceptionGroup
+
+func (s byLastSeen) Len() int           { return len(s) }
+func (s byLastSeen) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byLastSeen) Less(i, j int) bool { return s[i].LastSeen.After(s[j].LastSeen) }
+
+// Lists the exception groups of ?drain=<token>
+func serveExceptions(w http.ResponseWriter, r *http.Request) {
+	drain := r.URL.Query().Get("drain")
+	if drain == "" {
+		http.Error(w, "drain parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(exceptionDetector.Groups(drain))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExceptionGrouping(t *testing.T) {
+	detector := NewExceptionDetector()
+	trace := []string{
+		"NoMethodError (undefined method `name' for nil:NilClass):",
+		"  app/controllers/users_controller.rb:12:in `show'",
+		"  app/controllers/application_controller.rb:4:in `around'",
+		"Started GET \"/\" for 10.1.2.3",
+	}
+
+	for i, dyno := range []string{"web.1", "web.2"} {
+		var created []*exceptionGroup
+		for j, line := range trace {
+			var ok bool
+			ok, created = detector.Observe("d.1", "v1", dyno, []byte(line), 0)
+			if ok != (j < 3) {
+				t.Errorf("Line %q was recognized %t", line, ok)
+			}
+		}
+		if len(created) != 1-i {
+			t.Errorf("Expected %d new groups from %s, got %d", 1-i, dyno, len(created))
+		}
+	}
+
+	groups := detector.Groups("d.1")
+	if len(groups) != 1 {
+		t.Fatalf("Expected both traces in one group, got %d groups", len(groups))
+	}
+	if g := groups[0]; g.Class != "NoMethodError" || g.Count != 2 || len(g.Frames) != 2 || len(g.Dynos) != 2 {
+		t.Errorf("Unexpected group %+v", g)
+	}
+
+	detector.Observe("d.1", "v1", "web.1", []byte("panic: runtime error: index out of range"), 0)
+	if flushed := detector.Flush(time.Now().Add(exceptionTraceTimeout)); len(flushed) != 1 || flushed[0].Class != "panic" {
+		t.Errorf("Expected the idle panic to be flushed as a new group, got %+v", flushed)
+	}
+}
+
+func TestDrainExceptions(t *testing.T) {
+	token := "d.exceptions-test"
+	header := "<190>1 2014-07-02T16:52:38.123456+00:00 host app "
+	group := postToDrain(t, token,
+		header+"web.1 - NoMethodError (undefined method `name' for nil:NilClass):",
+		header+"web.1 -   app/controllers/users_controller.rb:12:in `show'",
+		header+"web.2 - TypeError: Cannot read property 'name' of undefined",
+		header+"web.2 -     at Object.show (/app/users.js:12:3)",
+		header+"web.3 - panic: runtime error: index out of range",
+		header+"web.3 - goroutine 1 [running]:",
+		header+"web.3 - main.handler(0xc20800a000, 0x1)",
+		header+"web.3 - \t/app/main.go:12 +0x1d",
+		// Ends the Ruby trace
+		header+"web.1 - Started GET \"/\" for 10.1.2.3",
+	)
+
+	if n := len(group.ExceptionGroups); n != 1 {
+		t.Errorf("Expected the finished Ruby trace to make a group, got %d", n)
+	}
+	exceptionDetector.Flush(time.Now().Add(exceptionTraceTimeout))
+
+	classes := make(map[string]bool)
+	for _, g := range exceptionDetector.Groups(token) {
+		classes[g.Class] = len(g.Frames) > 0
+	}
+	for _, class := range []string{"NoMethodError", "TypeError", "panic"} {
+		if hasFrames, ok := classes[class]; !ok || !hasFrames {
+			t.Errorf("Expected a %s group with frames, got %+v", class, classes)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Logplex retries a POST when it times out, re-sending the same
+// Logplex-Frame-Id. FrameDeduper remembers recently seen frame ids per drain
+// token so that retried frames are not counted twice.
+type FrameDeduper struct {
+	sync.Mutex
+	ttl      time.Duration
+	capacity int
+	drains   map[string]*frameSet
+	swept    time.Time // when drains were last checked for idle ones
+}
+
+type seenFrame struct {
+	id   string
+	seen time.Time
+}
+
+// Frame ids for a single drain, in the order they were first seen.
+type frameSet struct {
+	ids   map[string]time.Time
+	order []seenFrame
+}
+
+func NewFrameDeduper(capacity int, ttl time.Duration) *FrameDeduper {
+	return &FrameDeduper{
+		ttl:      ttl,
+		capacity: capacity,
+		drains:   make(map[string]*frameSet),
+		swept:    time.Now(),
+	}
+}
+
+// Seen records frameId for the given drain and reports whether it was already
+// seen within the ttl.
+func (d *FrameDeduper) Seen(drain, frameId string, now time.Time) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	// Forget drains that haven't sent anything for a ttl, once per ttl
+	if now.Sub(d.swept) >= d.ttl {
+		for token, set := range d.drains {
+			set.expire(now.Add(-d.ttl))
+			if len(set.order) == 0 {
+				delete(d.drains, token)
+			}
+		}
+		d.swept = now
+	}
+
+	set, ok := d.drains[drain]
+	if !ok {
+		set = &frameSet{ids: make(map[string]time.Time)}
+		d.drains[drain] = set
+	}
+
+	set.expire(now.Add(-d.ttl))
+
+	if _, ok := set.ids[frameId]; ok {
+		return true
+	}
+
+	// Drop the oldest frames once we are over capacity
+	for len(set.order) >= d.capacity {
+		delete(set.ids, set.order[0].id)
+		set.order = set.order[1:]
+	}
+
+	set.ids[frameId] = now
+	set.order = append(set.order, seenFrame{id: frameId, seen: now})
+	return false
+}
+
+// Forget drops frameId for the given drain, so a retry of a frame that could
+// not be read in full is processed again.
+func (d *FrameDeduper) Forget(drain, frameId string) {
+	d.Lock()
+	defer d.Unlock()
+
+	set, ok := d.drains[drain]
+	if !ok {
+		return
+	}
+	if _, ok := set.ids[frameId]; !ok {
+		return
+	}
+	delete(set.ids, frameId)
+	for i, f := range set.order {
+		if f.id == frameId {
+			set.order = append(set.order[:i], set.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Removes all frames seen before cutoff.
+func (s *frameSet) expire(cutoff time.Time) {
+	i := 0
+	for ; i < len(s.order) && s.order[i].seen.Before(cutoff); i++ {
+		delete(s.ids, s.order[i].id)
+	}
+	s.order = s.order[i:]
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFrameDeduper(t *testing.T) {
+	d := NewFrameDeduper(10, time.Minute)
+	now := time.Now()
+
+	if d.Seen("d.1", "frame-1", now) {
+		t.Errorf("Expected a new frame not to be seen")
+	}
+	if !d.Seen("d.1", "frame-1", now.Add(time.Second)) {
+		t.Errorf("Expected a retried frame to be seen")
+	}
+	if d.Seen("d.2", "frame-1", now) {
+		t.Errorf("Expected frame ids to be per drain")
+	}
+
+	// Retries can come in after newer frames
+	d.Seen("d.1", "frame-3", now.Add(2*time.Second))
+	d.Seen("d.1", "frame-2", now.Add(3*time.Second))
+	for _, id := range []string{"frame-1", "frame-2", "frame-3"} {
+		if !d.Seen("d.1", id, now.Add(4*time.Second)) {
+			t.Errorf("Expected %s to be seen out of order", id)
+		}
+	}
+
+	if d.Seen("d.1", "frame-1", now.Add(2*time.Minute)) {
+		t.Errorf("Expected frames to be forgotten past the ttl")
+	}
+}
+
+func TestFrameDeduperCapacity(t *testing.T) {
+	d := NewFrameDeduper(2, time.Minute)
+	now := time.Now()
+
+	d.Seen("d.1", "frame-1", now)
+	d.Seen("d.1", "frame-2", now)
+	d.Seen("d.1", "frame-3", now)
+
+	if d.Seen("d.1", "frame-1", now) {
+		t.Errorf("Expected the oldest frame to make room")
+	}
+	if !d.Seen("d.1", "frame-3", now) {
+		t.Errorf("Expected the newest frame to be kept")
+	}
+}
+
+func TestFrameDeduperEvictsIdleDrains(t *testing.T) {
+	d := NewFrameDeduper(10, time.Minute)
+	now := d.swept
+
+	d.Seen("d.1", "frame-1", now)
+	d.Seen("d.2", "frame-1", now.Add(30*time.Second))
+	d.Seen("d.2", "frame-2", now.Add(90*time.Second))
+
+	if _, ok := d.drains["d.1"]; ok {
+		t.Errorf("Expected the idle drain to be forgotten")
+	}
+	if _, ok := d.drains["d.2"]; !ok {
+		t.Errorf("Expected the active drain to be kept")
+	}
+}
+
+func TestDrainRetriesTruncatedFrame(t *testing.T) {
+	token := "d.truncated-frame-test"
+	group := NewChanGroup("test", 10)
+	defer func(ring *HashRing) { hashRing = ring }(hashRing)
+	hashRing = NewHashRing(1, nil)
+	hashRing.Add(group)
+
+	body := logplexFrames("<134>1 2014-07-02T16:52:38.123456+00:00 host app postgres.1234 - [DATABASE] [14-1] LOG:  duration: 2345.6 ms  statement: SELECT 1")
+	post := func(body []byte) {
+		r, err := http.NewRequest("POST", "/drain", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Unexpected error %q", err)
+		}
+		r.Header.Set("Logplex-Drain-Token", token)
+		r.Header.Set("Logplex-Frame-Id", "frame-1")
+		serveDrain(httptest.NewRecorder(), r)
+	}
+
+	post(body[:len(body)-10])
+	if top := slowQueries.Top(token, 10); len(top) != 0 {
+		t.Fatalf("Expected nothing from the truncated frame, got %+v", top)
+	}
+
+	post(body)
+	if top := slowQueries.Top(token, 10); len(top) != 1 {
+		t.Errorf("Expected the retry of a truncated frame to be processed, got %+v", top)
+	}
+}
+
+func TestFrameDeduperForget(t *testing.T) {
+	d := NewFrameDeduper(10, time.Minute)
+	now := time.Now()
+
+	d.Seen("d.1", "frame-1", now)
+	d.Seen("d.1", "frame-2", now)
+	d.Forget("d.1", "frame-1")
+
+	if d.Seen("d.1", "frame-1", now) {
+		t.Errorf("Expected a forgotten frame not to be seen")
+	}
+	if !d.Seen("d.1", "frame-2", now) {
+		t.Errorf("Expected other frames to be kept")
+	}
+}
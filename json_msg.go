@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// Fields nested deeper than this are kept as JSON
+const jsonFieldMaxDepth = 5
+
+// Well known keys of JSON loggers and the logfmt style names they are made
+// available under, first one found wins.
+var jsonWellKnownKeys = []struct {
+	name    string
+	aliases []string
+}{
+	{"level", []string{"level", "lvl", "severity", "log.level", "levelname"}},
+	{"duration", []string{"duration", "duration_ms", "elapsed", "elapsed_ms", "response_time", "took", "http.duration"}},
+	{"status", []string{"status", "status_code", "statusCode", "http.status", "http.status_code", "res.statusCode"}},
+	{"request_id", []string{"request_id", "requestId", "request-id", "req_id", "reqId", "http.request_id", "req.id"}},
+	{"error", []string{"error", "err", "error.message", "err.message", "exception", "error.msg"}},
+}
+
+// Collects the fields of a JSON app line, with nested objects flattened into
+// dotted keys: {"http":{"status":200}} becomes http.status=200. Returns false
+// if msg is not a JSON object.
+func parseJSONFields(msg []byte) (map[string]string, bool) {
+	msg = bytes.TrimSpace(msg)
+	if len(msg) < 2 || msg[0] != '{' || msg[len(msg)-1] != '}' {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(msg))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, false
+	}
+
+	fields := make(map[string]string)
+	flattenJSON(fields, "", object, 0)
+
+	for _, key := range jsonWellKnownKeys {
+		if _, ok := fields[key.name]; ok {
+			continue
+		}
+		for _, alias := range key.aliases {
+			if val, ok := fields[alias]; ok {
+				fields[key.name] = val
+				break
+			}
+		}
+	}
+	return fields, true
+}
+
+func flattenJSON(fields map[string]string, prefix string, object map[string]interface{}, depth int) {
+	for k, v := range object {
+		key := prefix + k
+		if nested, ok := v.(map[string]interface{}); ok && depth < jsonFieldMaxDepth {
+			flattenJSON(fields, key+".", nested, depth+1)
+			continue
+		}
+
+		switch val := v.(type) {
+		case nil:
+		case string:
+			if len(val) > 0 {
+				fields[key] = val
+			}
+		case json.Number:
+			fields[key] = val.String()
+		case bool:
+			fields[key] = strconv.FormatBool(val)
+		default:
+			if encoded, err := json.Marshal(val); err == nil {
+				fields[key] = string(encoded)
+			}
+		}
+	}
+}
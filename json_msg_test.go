@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseJSONFields(t *testing.T) {
+	line := `{"severity":"ERROR","msg":"boom","http":{"status_code":502,"request_id":"1f3ed8a9-c80c-49de-a4af-2df9f4ddb858"},"took":12.5,"ok":false,"tags":["a","b"],"user":null}`
+	fields, ok := parseJSONFields([]byte(line))
+	if !ok {
+		t.Fatalf("Expected %q to be parsed", line)
+	}
+
+	expected := map[string]string{
+		"severity":         "ERROR",
+		"msg":              "boom",
+		"http.status_code": "502",
+		"http.request_id":  "1f3ed8a9-c80c-49de-a4af-2df9f4ddb858",
+		"took":             "12.5",
+		"ok":               "false",
+		"tags":             `["a","b"]`,
+		"level":            "ERROR",
+		"status":           "502",
+		"duration":         "12.5",
+		"request_id":       "1f3ed8a9-c80c-49de-a4af-2df9f4ddb858",
+	}
+	if len(fields) != len(expected) {
+		t.Errorf("Expected %d fields, got %+v", len(expected), fields)
+	}
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Errorf("Expected %s=%s, got %q", k, v, fields[k])
+		}
+	}
+
+	if detectLogLevel([]byte(line), fields) != LogLevelError {
+		t.Errorf("Expected the JSON level to be detected")
+	}
+	if appRequestId([]byte(line), fields) != expected["request_id"] {
+		t.Errorf("Expected the nested request id to be picked up")
+	}
+
+	for _, line := range []string{`at=info msg="{not json}"`, `{"truncated": `, `["a"]`} {
+		if _, ok := parseJSONFields([]byte(line)); ok {
+			t.Errorf("Expected %q not to be parsed as JSON", line)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+	LogLevelFatal = "fatal"
+
+	// Weight of the latest window in the error rate baseline
+	logErrorBaselineWeight = 0.1
+	// Windows seen before spikes are alerted on
+	logErrorBaselineWindows = 5
+	// Errors a window needs before it can be a spike
+	logErrorSpikeMinCount = 10
+)
+
+var (
+	allLogLevels = []string{LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError, LogLevelFatal}
+
+	logLevelKeys = []string{"level", "lvl", "severity", "log_level", "loglevel"}
+
+	// {"level":"error",...}
+	jsonLogLevel = regexp.MustCompile(`"(?:level|lvl|severity|log_level|loglevel)"\s*:\s*"(\w+)"`)
+	// [WARN] ..., [error] ...
+	bracketLogLevel = regexp.MustCompile(`(?i)\[(trace|debug|info|notice|warn|warning|error|err|fatal|critical|crit|panic)\]`)
+	// E, [2014-07-02T16:52:38.123 #4] ERROR -- : ..., 2014/07/02 16:52:38 WARN ...
+	bareLogLevel = regexp.MustCompile(`(?:^|\s)(TRACE|DEBUG|INFO|NOTICE|WARN|WARNING|ERROR|FATAL|CRITICAL|PANIC)(?:\s|:|$)`)
+)
+
+// Maps the many spellings of log levels onto ours. Returns "" for anything
+// else.
+func normalizeLogLevel(level string) string {
+	switch strings.ToLower(level) {
+	case "trace", "debug":
+		return LogLevelDebug
+	case "info", "notice":
+		return LogLevelInfo
+	case "warn", "warning":
+		return LogLevelWarn
+	case "error", "err":
+		return LogLevelError
+	case "fatal", "critical", "crit", "panic", "emerg", "alert":
+		return LogLevelFatal
+	}
+	return ""
+}
+
+// Finds the level of a user line, from a logfmt or JSON level field, a
+// bracketed level or an upper case one. fields are the logfmt fields of msg.
+// Returns "" if the line has none.
+func detectLogLevel(msg []byte, fields map[string]string) string {
+	for _, key := range logLevelKeys {
+		if level := normalizeLogLevel(fields[key]); level != "" {
+			return level
+		}
+	}
+	for _, re := range []*regexp.Regexp{jsonLogLevel, bracketLogLevel, bareLogLevel} {
+		if m := re.FindSubmatch(msg); m != nil {
+			if level := normalizeLogLevel(string(m[1])); level != "" {
+				return level
+			}
+		}
+	}
+	return ""
+}
+
+// Lines per level of a process type over one aggregation window, and how its
+// error rate compares to the usual.
+type logLevelRollup struct {
+	ProcessType string
+	Lines       int
+	Counts      map[string]int
+	Rates       map[string]float64 // lines per second
+	ErrorRate   float64            // error and fatal lines per second
+	Baseline    float64            // moving average of ErrorRate
+	State       string
+
+	timestamp   int64
+	sourceDrain string
+}
+
+type logLevelWindow struct {
+	lines  int
+	counts map[string]int
+}
+
+type logErrorBaseline struct {
+	rate    float64
+	windows int
+}
+
+// LogLevels counts user lines per drain, process type and level and keeps a
+// moving baseline of each process type's error rate.
+type LogLevels struct {
+	sync.Mutex
+	spikeFactor float64
+	windowStart time.Time
+	windows     map[string]map[string]*logLevelWindow
+	baselines   map[string]map[string]*logErrorBaseline
+}
+
+func NewLogLevels(spikeFactor float64) *LogLevels {
+	return &LogLevels{
+		spikeFactor: spikeFactor,
+		windowStart: time.Now(),
+		windows:     make(map[string]map[string]*logLevelWindow),
+		baselines:   make(map[string]map[string]*logErrorBaseline),
+	}
+}
+
+// Records a user line of dyno, level being "" for lines without one.
+func (l *LogLevels) Observe(drain, dyno, level string) {
+	l.Lock()
+	defer l.Unlock()
+
+	types, ok := l.windows[drain]
+	if !ok {
+		types = make(map[string]*logLevelWindow)
+		l.windows[drain] = types
+	}
+	processType := dynoType(dyno)
+	w, ok := types[processType]
+	if !ok {
+		w = &logLevelWindow{counts: make(map[string]int)}
+		types[processType] = w
+	}
+
+	w.lines++
+	if level != "" {
+		w.counts[level]++
+	}
+}
+
+// How far rate is above the baseline. Errors have to be rising well past the
+// usual and be more than a handful before anyone gets woken up.
+func (l *LogLevels) spikeState(errors int, rate float64, baseline *logErrorBaseline) string {
+	if baseline.windows < logErrorBaselineWindows || errors < logErrorSpikeMinCount {
+		return "ok"
+	}
+	switch {
+	case rate > 2*l.spikeFactor*baseline.rate:
+		return "critical"
+	case rate > l.spikeFactor*baseline.rate:
+		return "warning"
+	}
+	return "ok"
+}
+
+// Returns the rollups for the window ending at now and starts a new window.
+func (l *LogLevels) Flush(now time.Time) []*logLevelRollup {
+	l.Lock()
+	defer l.Unlock()
+
+	seconds := now.Sub(l.windowStart).Seconds()
+	if seconds <= 0 {
+		seconds = 1
+	}
+	timestamp := now.UnixNano() / int64(time.Microsecond)
+
+	rollups := make([]*logLevelRollup, 0)
+	for drain, types := range l.windows {
+		baselines, ok := l.baselines[drain]
+		if !ok {
+			baselines = make(map[string]*logErrorBaseline)
+			l.baselines[drain] = baselines
+		}
+
+		for processType, w := range types {
+			rollup := &logLevelRollup{
+				ProcessType: processType,
+				Lines:       w.lines,
+				Counts:      w.counts,
+				Rates:       make(map[string]float64),
+				timestamp:   timestamp,
+				sourceDrain: drain,
+			}
+			for _, level := range allLogLevels {
+				rollup.Rates[level] = float64(w.counts[level]) / seconds
+			}
+			errors := w.counts[LogLevelError] + w.counts[LogLevelFatal]
+			rollup.ErrorRate = float64(errors) / seconds
+
+			baseline, ok := baselines[processType]
+			if !ok {
+				baseline = &logErrorBaseline{rate: rollup.ErrorRate}
+				baselines[processType] = baseline
+			}
+			rollup.Baseline = baseline.rate
+			rollup.State = l.spikeState(errors, rollup.ErrorRate, baseline)
+
+			baseline.rate += logErrorBaselineWeight * (rollup.ErrorRate - baseline.rate)
+			baseline.windows++
+
+			rollups = append(rollups, rollup)
+		}
+	}
+
+	// Process types that logged nothing had no errors either
+	for drain, baselines := range l.baselines {
+		for processType, baseline := range baselines {
+			if _, ok := l.windows[drain][processType]; ok {
+				continue
+			}
+			baseline.rate -= logErrorBaselineWeight * baseline.rate
+			baseline.windows++
+		}
+	}
+
+	l.windows = make(map[string]map[string]*logLevelWindow)
+	l.windowStart = now
+	return rollups
+}
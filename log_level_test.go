@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDetectLogLevel(t *testing.T) {
+	testCases := map[string]string{
+		`at=info method=GET level=error msg="boom"`:                  LogLevelError,
+		`{"level":"warning","msg":"slow"}`:                           LogLevelWarn,
+		`[WARN] disk almost full`:                                    LogLevelWarn,
+		`E, [2014-07-02T16:52:38.123 #4] ERROR -- : boom`:            LogLevelError,
+		`2014/07/02 16:52:38 FATAL: out of file descriptors`:         LogLevelFatal,
+		`Started GET "/users/1" for 10.1.2.3 at 2014-07-02 16:52:38`: "",
+		`Processing error_reports by ErrorsController#index as HTML`: "",
+	}
+
+	for line, expected := range testCases {
+		if level := detectLogLevel([]byte(line), parseAppFields([]byte(line))); level != expected {
+			t.Errorf("Detected %q in %q, expected %q", level, line, expected)
+		}
+	}
+}
+
+func TestLogErrorSpike(t *testing.T) {
+	levels := NewLogLevels(3)
+	now := time.Now()
+
+	for window := 0; window <= logErrorBaselineWindows; window++ {
+		errors := 10
+		if window == logErrorBaselineWindows {
+			errors = 100
+		}
+		for i := 0; i < errors; i++ {
+			levels.Observe("d.1", fmt.Sprintf("web.%d", i%2+1), LogLevelError)
+		}
+		levels.Observe("d.1", "web.1", "")
+
+		now = now.Add(time.Minute)
+		rollups := levels.Flush(now)
+		if len(rollups) != 1 || rollups[0].Lines != errors+1 {
+			t.Fatalf("Expected one web rollup of %d lines, got %+v", errors+1, rollups)
+		}
+
+		expected := "ok"
+		if window == logErrorBaselineWindows {
+			expected = "critical"
+		}
+		if rollups[0].State != expected {
+			t.Errorf("Window %d: expected %s, got %+v", window, expected, rollups[0])
+		}
+	}
+}
+
+func TestLogErrorBaselineDecaysWhenQuiet(t *testing.T) {
+	levels := NewLogLevels(3)
+	now := time.Now()
+
+	for window := 0; window < logErrorBaselineWindows; window++ {
+		for i := 0; i < 60; i++ {
+			levels.Observe("d.1", "web.1", LogLevelError)
+		}
+		now = now.Add(time.Minute)
+		levels.Flush(now)
+	}
+
+	// web logs nothing for a while
+	for window := 0; window < 20; window++ {
+		now = now.Add(time.Minute)
+		if rollups := levels.Flush(now); len(rollups) != 0 {
+			t.Fatalf("Expected no rollups without lines, got %+v", rollups)
+		}
+	}
+	if rate := levels.baselines["d.1"]["web"].rate; rate > 0.15 {
+		t.Errorf("Expected the baseline of 1 error/s to decay while quiet, got %.2f", rate)
+	}
+
+	// So the errors coming back are a spike
+	for i := 0; i < 60; i++ {
+		levels.Observe("d.1", "web.1", LogLevelError)
+	}
+	now = now.Add(time.Minute)
+	if rollups := levels.Flush(now); len(rollups) != 1 || rollups[0].State != "critical" {
+		t.Errorf("Expected errors after a quiet spell to be critical, got %+v", rollups)
+	}
+}
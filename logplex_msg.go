@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+var (
+	logplexErrorSentinel = []byte("Error L")
+	keyMessages          = []byte("messages")
+
+	errShortLogplexError = errors.New("Logplex error message is too short")
+)
+
+// Error L10 (output buffer overflow): 13 messages dropped since 2014-07-02T16:52:38+00:00.
+// Error L11 (Tail buffer overflow) -> This tail session dropped 1101 messages since 2014-07-02T16:52:38+00:00.
+//
+// Logplex tells the drain about data it had to throw away with these, so
+// every other metric for the drain is undercounted while they show up.
+type logplexError struct {
+	Code    int
+	Message string
+	Dropped int
+
+	timestamp   int64
+	sourceDrain string
+}
+
+func parseBytesToLogplexError(msg []byte) (logplexError, error) {
+	le := logplexError{Message: string(msg)}
+	if len(msg) < len(logplexErrorSentinel)+2 {
+		return le, errShortLogplexError
+	}
+	byteCode := msg[len(logplexErrorSentinel) : len(logplexErrorSentinel)+2]
+	code, err := strconv.Atoi(string(byteCode))
+	if err != nil {
+		return le, err
+	}
+	le.Code = code
+
+	// The dropped count is the number right before "messages", if any.
+	// L13 (local delivery error) doesn't report one.
+	fields := bytes.Fields(msg)
+	for i := 1; i < len(fields); i++ {
+		if bytes.Equal(fields[i], keyMessages) {
+			le.Dropped, _ = strconv.Atoi(string(fields[i-1]))
+			break
+		}
+	}
+	return le, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseLogplexError(t *testing.T) {
+	testCases := map[string]struct {
+		code    int
+		dropped int
+	}{
+		"Error L10 (output buffer overflow): 13 messages dropped since 2014-07-02T16:52:38+00:00.":                     {10, 13},
+		"Error L11 (Tail buffer overflow) -> This tail session dropped 1101 messages since 2014-07-02T16:52:38+00:00.": {11, 1101},
+		"Error L13 (Local delivery error)": {13, 0},
+	}
+
+	for msg, expected := range testCases {
+		le, err := parseBytesToLogplexError([]byte(msg))
+		if err != nil {
+			t.Errorf("Parsing %q returned error: %s", msg, err)
+			continue
+		}
+		if le.Code != expected.code || le.Dropped != expected.dropped {
+			t.Errorf("Parsing %q yielded code %d dropped %d, expected code %d dropped %d",
+				msg, le.Code, le.Dropped, expected.code, expected.dropped)
+		}
+	}
+
+	if _, err := parseBytesToLogplexError([]byte("Error L")); err == nil {
+		t.Errorf("Parsing a truncated message should have returned an error")
+	}
+}
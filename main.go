@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/heroku/slog"
@@ -13,6 +14,11 @@ const (
 	PointChannelCapacity = 100000
 	HashRingReplication  = 20 // TODO: Needs to be determined
 	PostersPerHost       = 6
+	FrameDedupCapacity   = 10000 // Frame ids remembered per drain
+	FrameDedupTTL        = 10 * time.Minute
+	CorrelationCapacity  = 100000 // Requests held on to for correlation
+	CorrelationWindow    = 15 * time.Second
+	CorrelationRetention = 5 * time.Minute
 )
 
 const (
@@ -31,13 +37,117 @@ var (
 
 	hashRing = NewHashRing(HashRingReplication, nil)
 
+	frameDeduper = NewFrameDeduper(FrameDedupCapacity, FrameDedupTTL)
+
+	batchStats = NewBatchStats()
+
+	dynoTracker = NewDynoTracker()
+
+	releaseTracker = NewReleaseTracker()
+
+	deployWatcher = NewDeployWatcher(DeployWindow)
+
+	processTypeAggregator = NewProcessTypeAggregator()
+
+	outlierDetector = NewOutlierDetector(OutlierThreshold)
+
+	memoryTrends = NewMemoryTrends(MemoryQuota)
+
+	requestCorrelator = NewRequestCorrelator(CorrelationCapacity, CorrelationWindow, CorrelationRetention)
+
+	railsRequests = NewRailsRequests()
+
+	slowQueries = NewSlowQueries()
+
+	exceptionDetector = NewExceptionDetector()
+
+	templateMiner = NewTemplateMiner()
+
+	logLevels = NewLogLevels(LogErrorSpikeFactor)
+
+	routeNormalizer = NewRouteNormalizer(MaxRoutes)
+
+	endpointAggregator = NewEndpointAggregator()
+
+	sloTracker = NewSloTracker()
+
+	apdexAggregator = NewApdexAggregator(ApdexT)
+
+	anomalyDetector = NewAnomalyDetector(AnomalyThreshold, os.Getenv("ANOMALY_SEASONAL") == "true")
+
+	concurrencyEstimator = NewConcurrencyEstimator()
+
+	// Per tenant settings, such as extraction rules
+	tenantConfigs = loadTenantConfigs(os.Getenv("TENANT_CONFIG"))
+
 	Debug = os.Getenv("DEBUG") == "true"
 
 	User          = os.Getenv("USER")
 	Password      = os.Getenv("PASSWORD")
 	RiemannPrefix = os.Getenv("RIEMANN_PREFIX")
+
+	// How long a web dyno may take to bind its port before it gets an R10.
+	// 60s unless Heroku has raised it for the app.
+	BootTimeout = envSeconds("BOOT_TIMEOUT", 60*time.Second)
+
+	// How much traffic before and after a release is compared
+	DeployWindow = envSeconds("DEPLOY_WINDOW", 10*time.Minute)
+
+	// How often windowed rollups are computed and sent
+	AggregationWindow = envSeconds("AGGREGATION_WINDOW", 60*time.Second)
+
+	// Robust standard deviations a dyno has to be above its peers to be
+	// flagged as an outlier
+	OutlierThreshold = envFloat("OUTLIER_THRESHOLD", 3)
+
+	// Memory quota of the app's dynos in MB, 512 for 1X dynos
+	MemoryQuota = envFloat("MEMORY_QUOTA", 512)
+
+	// How far ahead a dyno projected to exceed its memory quota is warned about
+	MemoryLeakHorizon = envSeconds("MEMORY_LEAK_HORIZON", time.Hour)
+
+	// Connection limits of the Postgres and Redis plans, 0 for no alerting
+	PostgresConnectionLimit = int(envFloat("POSTGRES_CONNECTION_LIMIT", 120))
+	RedisConnectionLimit    = int(envFloat("REDIS_CONNECTION_LIMIT", 0))
+
+	// How many times its usual error rate a process type has to log before
+	// it is alerted on
+	LogErrorSpikeFactor = envFloat("LOG_ERROR_SPIKE_FACTOR", 3)
+
+	// Distinct routes kept per drain, the rest are reported as "other"
+	MaxRoutes = int(envFloat("MAX_ROUTES", defaultMaxRoutes))
+
+	// Apdex T in ms for tenants that don't set their own
+	ApdexT = envFloat("APDEX_T", defaultApdexT)
+
+	// Standard deviations a host's traffic, latency or errors have to stray
+	// from their baseline to be reported
+	AnomalyThreshold = envFloat("ANOMALY_THRESHOLD", 3)
+
+	// Where the baselines are kept across restarts, if anywhere
+	AnomalyStateFile = os.Getenv("ANOMALY_STATE_FILE")
 )
 
+// Reads a number from the environment, falling back to def if the variable is
+// unset or not a number.
+func envFloat(name string, def float64) float64 {
+	f, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
+// Reads a number of seconds from the environment, falling back to def if the
+// variable is unset or not a number.
+func envSeconds(name string, def time.Duration) time.Duration {
+	seconds, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil {
+		return def
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
 func LogWithContext(ctx slog.Context) {
 	ctx.Add("app", "lumbermill")
 	log.Println(ctx)
@@ -59,6 +169,12 @@ func main() {
 
 	hashRing.Add(chanGroups...)
 
+	if AnomalyStateFile != "" {
+		if err := anomalyDetector.Load(AnomalyStateFile); err != nil {
+			log.Printf("Unable to load anomaly baselines from %s: %q\n", AnomalyStateFile, err)
+		}
+	}
+
 	// Some statistics about the channels this way we can see how full they are getting
 	go func() {
 		for {
@@ -67,10 +183,74 @@ func main() {
 			for _, group := range chanGroups {
 				group.Sample(ctx)
 			}
+			batchStats.Sample(ctx)
 			LogWithContext(ctx)
 		}
 	}()
 
+	// Hand finished deploy reports to whoever posts for the drain
+	go func() {
+		for {
+			time.Sleep(30 * time.Second)
+			for _, report := range deployWatcher.Due(time.Now()) {
+				hashRing.Get(report.Drain).DeployReports <- report
+			}
+		}
+	}()
+
+	// Emit requests once their app lines had a chance to come in
+	go func() {
+		for {
+			time.Sleep(CorrelationWindow)
+			for _, req := range requestCorrelator.Flush(time.Now()) {
+				hashRing.Get(req.sourceDrain).CorrelatedRequests <- req
+			}
+		}
+	}()
+
+	// Flush the windowed aggregations
+	go func() {
+		for {
+			time.Sleep(AggregationWindow)
+			now := time.Now()
+			for _, rollup := range processTypeAggregator.Flush(now) {
+				hashRing.Get(rollup.sourceDrain).ProcessTypeRollups <- rollup
+			}
+			for _, outlier := range outlierDetector.Flush(now) {
+				hashRing.Get(outlier.sourceDrain).DynoOutliers <- outlier
+			}
+			for _, rollup := range slowQueries.Flush(now) {
+				hashRing.Get(rollup.sourceDrain).SlowQueryRollups <- rollup
+			}
+			for _, group := range exceptionDetector.Flush(now) {
+				hashRing.Get(group.sourceDrain).ExceptionGroups <- group
+			}
+			for _, rollup := range logLevels.Flush(now) {
+				hashRing.Get(rollup.sourceDrain).LogLevelRollups <- rollup
+			}
+			for _, rollup := range endpointAggregator.Flush(now) {
+				hashRing.Get(rollup.sourceDrain).EndpointRollups <- rollup
+			}
+			for _, status := range sloTracker.Flush(now) {
+				hashRing.Get(status.sourceDrain).SloStatuses <- status
+			}
+			for _, rollup := range apdexAggregator.Flush(now) {
+				hashRing.Get(rollup.sourceDrain).ApdexRollups <- rollup
+			}
+			for _, event := range anomalyDetector.Flush(now) {
+				hashRing.Get(event.sourceDrain).AnomalyEvents <- event
+			}
+			for _, rollup := range concurrencyEstimator.Flush(now) {
+				hashRing.Get(rollup.sourceDrain).ConcurrencyRollups <- rollup
+			}
+			if AnomalyStateFile != "" {
+				if err := anomalyDetector.Save(AnomalyStateFile); err != nil {
+					log.Printf("Unable to save anomaly baselines to %s: %q\n", AnomalyStateFile, err)
+				}
+			}
+		}
+	}()
+
 	// Every 5 minutes, signal that the connection should be closed
 	// This should allow for a slow balancing of connections.
 	go func() {
@@ -82,5 +262,12 @@ func main() {
 
 	http.HandleFunc("/drain", serveDrain)
 	http.HandleFunc("/health", serveHealth)
+	http.HandleFunc("/dynos", requireAuth(serveDynos))
+	http.HandleFunc("/deploys", requireAuth(serveDeploys))
+	http.HandleFunc("/requests", requireAuth(serveRequests))
+	http.HandleFunc("/queries", requireAuth(serveSlowQueries))
+	http.HandleFunc("/exceptions", requireAuth(serveExceptions))
+	http.HandleFunc("/templates", requireAuth(serveTemplates))
+	http.HandleFunc("/slos", requireAuth(serveSlos))
 	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Memory samples a trend is fitted over. Dynos report about every 20s,
+	// so this is roughly the last half hour.
+	memoryTrendSamples = 90
+	// Samples needed before a trend is reported
+	memoryTrendMinSamples = 10
+	// Dynos that haven't reported for this long are forgotten, so one-off
+	// and scaled down dynos don't pile up
+	memoryTrendDynoTTL = 10 * time.Minute
+)
+
+type memorySample struct {
+	at     int64 // microseconds
+	memory float64
+}
+
+// MemoryTrends fits a line through the recent memory samples of each dyno to
+// tell a slow leak from a dyno that merely runs hot, and to project when it
+// will run out of memory.
+type MemoryTrends struct {
+	sync.Mutex
+	quota  float64
+	drains map[string]map[string][]memorySample
+	swept  int64 // microseconds, when drains were last checked for idle dynos
+}
+
+func NewMemoryTrends(quota float64) *MemoryTrends {
+	return &MemoryTrends{
+		quota:  quota,
+		drains: make(map[string]map[string][]memorySample),
+	}
+}
+
+// Adds dm to the history of its dyno and fills in its growth rate and the
+// projected time until the dyno exceeds its quota.
+func (t *MemoryTrends) Observe(dm *dynoMemMsg) {
+	t.Lock()
+	defer t.Unlock()
+
+	if ttl := int64(memoryTrendDynoTTL / time.Microsecond); dm.timestamp-t.swept >= ttl {
+		for drain, dynos := range t.drains {
+			for dyno, samples := range dynos {
+				if dm.timestamp-samples[len(samples)-1].at > ttl {
+					delete(dynos, dyno)
+				}
+			}
+			if len(dynos) == 0 {
+				delete(t.drains, drain)
+			}
+		}
+		t.swept = dm.timestamp
+	}
+
+	dynos, ok := t.drains[dm.sourceDrain]
+	if !ok {
+		dynos = make(map[string][]memorySample)
+		t.drains[dm.sourceDrain] = dynos
+	}
+
+	samples := dynos[dm.Source]
+
+	// A big drop means the dyno restarted without us seeing it
+	if n := len(samples); n > 0 && dm.MemoryTotal < samples[n-1].memory/2 {
+		samples = nil
+	}
+
+	samples = append(samples, memorySample{at: dm.timestamp, memory: dm.MemoryTotal})
+	if len(samples) > memoryTrendSamples {
+		samples = samples[len(samples)-memoryTrendSamples:]
+	}
+	dynos[dm.Source] = samples
+
+	if len(samples) < memoryTrendMinSamples {
+		return
+	}
+
+	xs := make([]float64, len(samples))
+	ys := make([]float64, len(samples))
+	for i, s := range samples {
+		xs[i] = float64(s.at-samples[0].at) / float64(time.Second/time.Microsecond)
+		ys[i] = s.memory
+	}
+	slope, intercept := linearRegression(xs, ys)
+
+	dm.trendSamples = len(samples)
+	dm.growthRate = slope
+	dm.timeToQuota = -1
+	if slope > 0 {
+		remaining := t.quota - (slope*xs[len(xs)-1] + intercept)
+		if remaining < 0 {
+			remaining = 0
+		}
+		dm.timeToQuota = time.Duration(remaining / slope * float64(time.Second))
+	}
+}
+
+// Forgets the history of dyno, e.g. because it restarted.
+func (t *MemoryTrends) Reset(drain, dyno string) {
+	t.Lock()
+	defer t.Unlock()
+
+	if dynos, ok := t.drains[drain]; ok {
+		delete(dynos, dyno)
+	}
+}
+
+// How soon a dyno is projected to exceed its quota
+func memoryTrendState(timeToQuota time.Duration) string {
+	switch {
+	case timeToQuota < 0:
+		return "ok"
+	case timeToQuota <= MemoryLeakHorizon/6:
+		return "critical"
+	case timeToQuota <= MemoryLeakHorizon:
+		return "warning"
+	}
+	return "ok"
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Feeds samples of web.1 20s apart, starting at start MB and growing by
+// growth MB per second, and returns the last one.
+func observeMemoryTrend(trends *MemoryTrends, samples int, start, growth float64) *dynoMemMsg {
+	var dm *dynoMemMsg
+	for i := 0; i < samples; i++ {
+		seconds := float64(i * 20)
+		dm = &dynoMemMsg{
+			Source:      "web.1",
+			MemoryTotal: start + growth*seconds,
+			timestamp:   int64(seconds) * int64(time.Second/time.Microsecond),
+			sourceDrain: "d.1",
+		}
+		trends.Observe(dm)
+	}
+	return dm
+}
+
+func TestMemoryTrends(t *testing.T) {
+	trends := NewMemoryTrends(512)
+
+	if dm := observeMemoryTrend(trends, memoryTrendMinSamples-1, 300, 0.1); dm.trendSamples != 0 {
+		t.Errorf("Expected no trend before %d samples, got %+v", memoryTrendMinSamples, dm)
+	}
+	trends.Reset("d.1", "web.1")
+
+	// 0.1MB/s from 300MB reaches 512MB 1940s after the 10th sample at 318MB
+	dm := observeMemoryTrend(trends, memoryTrendMinSamples, 300, 0.1)
+	if dm.trendSamples != memoryTrendMinSamples || dm.growthRate < 0.0999 || dm.growthRate > 0.1001 {
+		t.Errorf("Expected a growth rate of 0.1MB/s, got %+v", dm)
+	}
+	if dm.timeToQuota < 1939*time.Second || dm.timeToQuota > 1941*time.Second {
+		t.Errorf("Expected to hit the quota in 1940s, got %s", dm.timeToQuota)
+	}
+	trends.Reset("d.1", "web.1")
+
+	if dm := observeMemoryTrend(trends, memoryTrendMinSamples, 300, 0); dm.timeToQuota != -1 {
+		t.Errorf("Expected flat memory never to hit the quota, got %s", dm.timeToQuota)
+	}
+}
+
+func TestMemoryTrendState(t *testing.T) {
+	testCases := map[time.Duration]string{
+		-1:                                "ok",
+		2 * MemoryLeakHorizon:             "ok",
+		MemoryLeakHorizon / 2:             "warning",
+		MemoryLeakHorizon/6 - time.Second: "critical",
+		MemoryLeakHorizon/6 + time.Minute: "warning",
+	}
+	for timeToQuota, expected := range testCases {
+		if state := memoryTrendState(timeToQuota); state != expected {
+			t.Errorf("Hitting the quota in %s is %s, expected %s", timeToQuota, state, expected)
+		}
+	}
+}
+
+func TestMemoryTrendsForgetIdleDynos(t *testing.T) {
+	trends := NewMemoryTrends(512)
+	start := time.Now().UnixNano() / int64(time.Microsecond)
+	observe := func(drain, dyno string, at int64) {
+		trends.Observe(&dynoMemMsg{Source: dyno, MemoryTotal: 300, timestamp: at, sourceDrain: drain})
+	}
+
+	observe("d.1", "web.1", start)
+	observe("d.1", "run.1234", start)
+	observe("d.2", "web.1", start)
+
+	observe("d.1", "web.1", start+int64(5*time.Minute/time.Microsecond))
+	observe("d.1", "web.1", start+int64((memoryTrendDynoTTL+time.Minute)/time.Microsecond))
+
+	if _, ok := trends.drains["d.1"]["run.1234"]; ok {
+		t.Errorf("Expected the idle dyno to be forgotten")
+	}
+	if _, ok := trends.drains["d.2"]; ok {
+		t.Errorf("Expected the idle drain to be forgotten")
+	}
+	if n := len(trends.drains["d.1"]["web.1"]); n != 3 {
+		t.Errorf("Expected the active dyno to keep its 3 samples, got %d", n)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// Aggregation windows a dyno is judged over
+	outlierWindows = 5
+	// Dynos of a process type needed before comparing them makes sense
+	outlierMinPeers = 3
+	// Requests a dyno needs over the windows for its latency and error rate
+	// to be compared
+	outlierMinRequests = 20
+)
+
+// What a dyno is compared to its peers on
+const (
+	OutlierLatency   = "latency"
+	OutlierErrorRate = "error rate"
+	OutlierMemory    = "memory"
+	OutlierLoad      = "load"
+)
+
+// Smallest spread assumed among peers per metric, so that perfectly uniform
+// peers don't make every tiny difference an outlier
+var outlierMinScale = map[string]float64{
+	OutlierLatency:   10,   // ms
+	OutlierErrorRate: 0.01, // 1 point
+	OutlierMemory:    10,   // MB
+	OutlierLoad:      0.1,
+}
+
+// A dyno that drifted away from the other dynos of its process type
+type dynoOutlier struct {
+	Dyno        string
+	ProcessType string
+	Metric      string
+	Value       float64
+	PeerMedian  float64
+	Peers       int
+	Score       float64
+
+	timestamp   int64
+	sourceDrain string
+}
+
+// Router and runtime metrics of one dyno over one aggregation window
+type outlierWindow struct {
+	requests   int
+	errors     int
+	latencySum float64
+	memory     float64
+	memorySeen bool
+	load       float64
+	loadSeen   bool
+}
+
+type dynoHistory struct {
+	current *outlierWindow
+	past    []*outlierWindow // Oldest first
+}
+
+// OutlierDetector compares each dyno with the other dynos of its process type
+// over a sliding window and flags the ones that stand out, using the median
+// and median absolute deviation of the peers so a single bad dyno can't hide
+// itself by skewing the baseline.
+type OutlierDetector struct {
+	sync.Mutex
+	threshold float64
+	drains    map[string]map[string]*dynoHistory
+}
+
+func NewOutlierDetector(threshold float64) *OutlierDetector {
+	return &OutlierDetector{
+		threshold: threshold,
+		drains:    make(map[string]map[string]*dynoHistory),
+	}
+}
+
+// Must be called with the lock held.
+func (d *OutlierDetector) window(drain, dyno string) *outlierWindow {
+	dynos, ok := d.drains[drain]
+	if !ok {
+		dynos = make(map[string]*dynoHistory)
+		d.drains[drain] = dynos
+	}
+
+	history, ok := dynos[dyno]
+	if !ok {
+		history = &dynoHistory{current: &outlierWindow{}}
+		dynos[dyno] = history
+	}
+	return history.current
+}
+
+// Records a request served by dyno. latency is connect + service time in ms.
+func (d *OutlierDetector) ObserveRouter(drain, dyno string, latency float64, failed bool) {
+	if dyno == "" {
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	w := d.window(drain, dyno)
+	w.requests++
+	w.latencySum += latency
+	if failed {
+		w.errors++
+	}
+}
+
+// Records the memory total of dyno in MB.
+func (d *OutlierDetector) ObserveMemory(drain, dyno string, memory float64) {
+	d.Lock()
+	defer d.Unlock()
+
+	w := d.window(drain, dyno)
+	w.memory, w.memorySeen = memory, true
+}
+
+// Records the 1 minute load average of dyno.
+func (d *OutlierDetector) ObserveLoad(drain, dyno string, load float64) {
+	d.Lock()
+	defer d.Unlock()
+
+	w := d.window(drain, dyno)
+	w.load, w.loadSeen = load, true
+}
+
+// Closes the current window and returns the dynos that are outliers over the
+// last few windows.
+func (d *OutlierDetector) Flush(now time.Time) []*dynoOutlier {
+	d.Lock()
+	defer d.Unlock()
+
+	timestamp := now.UnixNano() / int64(time.Microsecond)
+	outliers := make([]*dynoOutlier, 0)
+
+	for drain, dynos := range d.drains {
+		// metric -> process type -> dyno -> value
+		values := map[string]map[string]map[string]float64{
+			OutlierLatency:   make(map[string]map[string]float64),
+			OutlierErrorRate: make(map[string]map[string]float64),
+			OutlierMemory:    make(map[string]map[string]float64),
+			OutlierLoad:      make(map[string]map[string]float64),
+		}
+		add := func(metric, dyno string, value float64) {
+			processType := dynoType(dyno)
+			if values[metric][processType] == nil {
+				values[metric][processType] = make(map[string]float64)
+			}
+			values[metric][processType][dyno] = value
+		}
+
+		for dyno, history := range dynos {
+			history.past = append(history.past, history.current)
+			if len(history.past) > outlierWindows {
+				history.past = history.past[len(history.past)-outlierWindows:]
+			}
+			history.current = &outlierWindow{}
+
+			requests, errors, latencySum := 0, 0, 0.0
+			memory, memorySeen, load, loadSeen := 0.0, false, 0.0, false
+			for _, w := range history.past {
+				requests += w.requests
+				errors += w.errors
+				latencySum += w.latencySum
+				if w.memorySeen {
+					memory, memorySeen = w.memory, true
+				}
+				if w.loadSeen {
+					load, loadSeen = w.load, true
+				}
+			}
+
+			// Dynos that went quiet for the whole window are gone
+			if requests == 0 && !memorySeen && !loadSeen {
+				delete(dynos, dyno)
+				continue
+			}
+
+			if requests >= outlierMinRequests {
+				add(OutlierLatency, dyno, latencySum/float64(requests))
+				add(OutlierErrorRate, dyno, float64(errors)/float64(requests))
+			}
+			if memorySeen {
+				add(OutlierMemory, dyno, memory)
+			}
+			if loadSeen {
+				add(OutlierLoad, dyno, load)
+			}
+		}
+
+		for metric, types := range values {
+			for processType, peers := range types {
+				for _, outlier := range d.outliers(peers, outlierMinScale[metric]) {
+					outlier.ProcessType = processType
+					outlier.Metric = metric
+					outlier.timestamp = timestamp
+					outlier.sourceDrain = drain
+					outliers = append(outliers, outlier)
+				}
+			}
+		}
+	}
+	return outliers
+}
+
+// Returns the dynos in peers whose value is more than threshold robust
+// standard deviations above the median of all of them.
+func (d *OutlierDetector) outliers(peers map[string]float64, minScale float64) []*dynoOutlier {
+	outliers := make([]*dynoOutlier, 0)
+	if len(peers) < outlierMinPeers {
+		return outliers
+	}
+
+	med := percentile(mapValues(peers), 0.5)
+	deviations := make([]float64, 0, len(peers))
+	for _, v := range peers {
+		deviations = append(deviations, math.Abs(v-med))
+	}
+
+	// 1.4826 * MAD estimates the standard deviation for normal data
+	scale := 1.4826 * percentile(deviations, 0.5)
+	if floor := math.Max(0.1*math.Abs(med), minScale); scale < floor {
+		scale = floor
+	}
+
+	for dyno, v := range peers {
+		if score := (v - med) / scale; score >= d.threshold {
+			outliers = append(outliers, &dynoOutlier{
+				Dyno:       dyno,
+				Value:      v,
+				PeerMedian: med,
+				Peers:      len(peers),
+				Score:      score,
+			})
+		}
+	}
+	return outliers
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestOutlierDetectorSlowDyno(t *testing.T) {
+	d := NewOutlierDetector(3)
+
+	// web.1 to web.4 take around 100ms, web.5 500ms
+	for i := 1; i <= 5; i++ {
+		dyno := fmt.Sprintf("web.%d", i)
+		latency := 95 + float64(i*2)
+		if i == 5 {
+			latency = 500
+		}
+		for j := 0; j < 30; j++ {
+			d.ObserveRouter("d.1", dyno, latency, false)
+		}
+		d.ObserveMemory("d.1", dyno, 300)
+	}
+
+	outliers := d.Flush(time.Now())
+	if len(outliers) != 1 {
+		t.Fatalf("Expected one outlier, got %+v", outliers)
+	}
+	outlier := outliers[0]
+	if outlier.Dyno != "web.5" || outlier.Metric != OutlierLatency || outlier.ProcessType != "web" || outlier.Peers != 5 {
+		t.Errorf("Unexpected outlier %+v", *outlier)
+	}
+	if outlier.Value != 500 || outlier.PeerMedian != 101 || outlier.Score < 3 {
+		t.Errorf("Unexpected score %+v", *outlier)
+	}
+}
+
+func TestOutlierDetectorTooFewPeers(t *testing.T) {
+	d := NewOutlierDetector(3)
+
+	for j := 0; j < 30; j++ {
+		d.ObserveRouter("d.1", "web.1", 100, false)
+		d.ObserveRouter("d.1", "web.2", 1000, false)
+	}
+
+	if outliers := d.Flush(time.Now()); len(outliers) != 0 {
+		t.Errorf("Expected no outliers among 2 dynos, got %+v", outliers)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Router latencies kept per process type and window
+const processTypeLatencySamples = 2000
+
+// Memory, load and router latency rolled up across all dynos of a process
+// type, e.g. every web.N dyno, over one aggregation window.
+type processTypeRollup struct {
+	ProcessType string
+	Dynos       int
+
+	MemoryAvg float64
+	MemoryMax float64
+	MemoryP95 float64
+
+	LoadAvg float64
+	LoadMax float64
+	LoadP95 float64
+
+	Requests   int
+	LatencyAvg float64
+	LatencyP95 float64
+	LatencyMax float64
+
+	timestamp   int64
+	sourceDrain string
+}
+
+// Latest samples per dyno and router latencies for one process type
+type processTypeWindow struct {
+	memory     map[string]float64
+	load       map[string]float64
+	latencies  *reservoir
+	latencySum float64
+	latencyMax float64
+}
+
+func newProcessTypeWindow() *processTypeWindow {
+	return &processTypeWindow{
+		memory:    make(map[string]float64),
+		load:      make(map[string]float64),
+		latencies: newReservoir(processTypeLatencySamples),
+	}
+}
+
+// ProcessTypeAggregator rolls dyno and router samples up per drain and
+// process type.
+type ProcessTypeAggregator struct {
+	sync.Mutex
+	drains map[string]map[string]*processTypeWindow
+}
+
+func NewProcessTypeAggregator() *ProcessTypeAggregator {
+	return &ProcessTypeAggregator{drains: make(map[string]map[string]*processTypeWindow)}
+}
+
+// Must be called with the lock held.
+func (a *ProcessTypeAggregator) window(drain, dyno string) *processTypeWindow {
+	types, ok := a.drains[drain]
+	if !ok {
+		types = make(map[string]*processTypeWindow)
+		a.drains[drain] = types
+	}
+
+	processType := dynoType(dyno)
+	w, ok := types[processType]
+	if !ok {
+		w = newProcessTypeWindow()
+		types[processType] = w
+	}
+	return w
+}
+
+// Records the memory total of dyno in MB. Only the latest sample of a dyno in
+// a window counts, so every dyno weighs the same.
+func (a *ProcessTypeAggregator) ObserveMemory(drain, dyno string, memory float64) {
+	a.Lock()
+	defer a.Unlock()
+	a.window(drain, dyno).memory[dyno] = memory
+}
+
+// Records the 1 minute load average of dyno.
+func (a *ProcessTypeAggregator) ObserveLoad(drain, dyno string, load float64) {
+	a.Lock()
+	defer a.Unlock()
+	a.window(drain, dyno).load[dyno] = load
+}
+
+// Records a request served by dyno. latency is connect + service time in ms.
+func (a *ProcessTypeAggregator) ObserveRouter(drain, dyno string, latency float64) {
+	if dyno == "" {
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	w := a.window(drain, dyno)
+	w.latencies.Add(latency)
+	w.latencySum += latency
+	if latency > w.latencyMax {
+		w.latencyMax = latency
+	}
+}
+
+// Returns the rollups for the window ending at now and starts a new window.
+func (a *ProcessTypeAggregator) Flush(now time.Time) []*processTypeRollup {
+	a.Lock()
+	drains := a.drains
+	a.drains = make(map[string]map[string]*processTypeWindow)
+	a.Unlock()
+
+	timestamp := now.UnixNano() / int64(time.Microsecond)
+	rollups := make([]*processTypeRollup, 0)
+	for drain, types := range drains {
+		for processType, w := range types {
+			rollup := &processTypeRollup{
+				ProcessType: processType,
+				Requests:    w.latencies.seen,
+				LatencyAvg:  ratio(w.latencySum, float64(w.latencies.seen)),
+				LatencyP95:  percentile(w.latencies.values, 0.95),
+				LatencyMax:  w.latencyMax,
+				timestamp:   timestamp,
+				sourceDrain: drain,
+			}
+
+			memory := mapValues(w.memory)
+			rollup.MemoryAvg, rollup.MemoryMax, rollup.MemoryP95 = mean(memory), maximum(memory), percentile(memory, 0.95)
+
+			load := mapValues(w.load)
+			rollup.LoadAvg, rollup.LoadMax, rollup.LoadP95 = mean(load), maximum(load), percentile(load, 0.95)
+
+			rollup.Dynos = len(w.memory)
+			if len(w.load) > rollup.Dynos {
+				rollup.Dynos = len(w.load)
+			}
+
+			rollups = append(rollups, rollup)
+		}
+	}
+	return rollups
+}
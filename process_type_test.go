@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProcessTypeAggregator(t *testing.T) {
+	a := NewProcessTypeAggregator()
+
+	// Only the latest sample of a dyno counts
+	a.ObserveMemory("d.1", "web.1", 100)
+	a.ObserveMemory("d.1", "web.1", 200)
+	a.ObserveMemory("d.1", "web.2", 300)
+	a.ObserveLoad("d.1", "web.1", 0.5)
+	a.ObserveLoad("d.1", "web.2", 1.5)
+	a.ObserveRouter("d.1", "web.1", 10)
+	a.ObserveRouter("d.1", "web.1", 20)
+	a.ObserveRouter("d.1", "web.2", 30)
+	a.ObserveRouter("d.1", "", 1000)
+	a.ObserveMemory("d.1", "worker.1", 50)
+
+	rollups := make(map[string]*processTypeRollup)
+	for _, rollup := range a.Flush(time.Now()) {
+		if rollup.sourceDrain != "d.1" {
+			t.Errorf("Unexpected drain %q", rollup.sourceDrain)
+		}
+		rollups[rollup.ProcessType] = rollup
+	}
+	if len(rollups) != 2 {
+		t.Fatalf("Expected web and worker rollups, got %+v", rollups)
+	}
+
+	web := rollups["web"]
+	if web.Dynos != 2 || web.MemoryAvg != 250 || web.MemoryMax != 300 || web.MemoryP95 != 300 {
+		t.Errorf("Unexpected web memory %+v", *web)
+	}
+	if web.LoadAvg != 1 || web.LoadMax != 1.5 {
+		t.Errorf("Unexpected web load %+v", *web)
+	}
+	if web.Requests != 3 || web.LatencyAvg != 20 || web.LatencyP95 != 30 || web.LatencyMax != 30 {
+		t.Errorf("Unexpected web latency %+v", *web)
+	}
+
+	worker := rollups["worker"]
+	if worker.Dynos != 1 || worker.MemoryAvg != 50 || worker.Requests != 0 || worker.LatencyAvg != 0 {
+		t.Errorf("Unexpected worker rollup %+v", *worker)
+	}
+
+	if rollups := a.Flush(time.Now()); len(rollups) != 0 {
+		t.Errorf("Expected a new window to start empty, got %+v", rollups)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	FrameworkRails = "rails"
+	FrameworkRack  = "rack"
+
+	// Requests between a Started/Processing and a Completed line we keep track
+	// of per drain
+	railsPendingCapacity = 10000
+)
+
+var (
+	railsStarted    = regexp.MustCompile(`^Started (\S+) "([^"]*)"`)
+	railsProcessing = regexp.MustCompile(`^Processing by (\S+)#(\S+) as (\S+)`)
+	railsCompleted  = regexp.MustCompile(`^Completed (\d{3}) .*?in ([\d.]+)ms(?: \(([^)]*)\))?`)
+
+	// What Rack::CommonLogger writes for Puma and Unicorn
+	// 10.1.2.3 - - [02/Jul/2014:16:52:38 +0000] "GET /users/1 HTTP/1.1" 200 1234 0.0123
+	rackCommonLog = regexp.MustCompile(`^\S+ - \S+ \[[^\]]+\] "(\S+) (\S+)[^"]*" (\d{3}) (\S+) ([\d.]+)`)
+
+	railsViewsKey        = []byte("Views")
+	railsActiveRecordKey = []byte("ActiveRecord")
+)
+
+// A request as reported by the app server, as opposed to the router. Rails
+// tells us which controller action handled it and where the time went.
+type appRequestMsg struct {
+	Framework  string
+	Controller string
+	Action     string
+	Format     string
+	Method     string
+	Path       string
+	Route      string // Path as a route, e.g. /users/:id
+	Dyno       string
+	Status     int
+	Duration   float64 // ms
+	ViewTime   float64 // ms
+	DbTime     float64 // ms
+
+	timestamp   int64
+	sourceDrain string
+	release     string
+}
+
+// Strips the leading "[tag] " groups Rails' tagged logging adds and returns
+// them along with the rest of the line.
+func stripLogTags(msg []byte) ([][]byte, []byte) {
+	tags := make([][]byte, 0)
+	for len(msg) > 0 && msg[0] == '[' {
+		end := bytes.IndexByte(msg, ']')
+		if end < 0 {
+			break
+		}
+		tags = append(tags, msg[1:end])
+		msg = bytes.TrimLeft(msg[end+1:], " ")
+	}
+	return tags, msg
+}
+
+// Parses a Rack::CommonLogger line.
+func parseBytesToRackRequest(msg []byte) (appRequestMsg, bool) {
+	m := rackCommonLog.FindSubmatch(msg)
+	if m == nil {
+		return appRequestMsg{}, false
+	}
+
+	ar := appRequestMsg{
+		Framework: FrameworkRack,
+		Method:    string(m[1]),
+		Path:      string(m[2]),
+	}
+	ar.Status, _ = strconv.Atoi(string(m[3]))
+	seconds, _ := strconv.ParseFloat(string(m[5]), 64)
+	ar.Duration = seconds * 1000
+	return ar, true
+}
+
+// Parses the "(Views: 40.1ms | ActiveRecord: 60.2ms)" breakdown of a
+// Completed line into ar.
+func parseRailsBreakdown(breakdown []byte, ar *appRequestMsg) {
+	for _, part := range bytes.Split(breakdown, []byte("|")) {
+		kv := bytes.SplitN(bytes.TrimSpace(part), []byte(": "), 2)
+		if len(kv) != 2 {
+			continue
+		}
+		ms, err := strconv.ParseFloat(string(bytes.TrimSuffix(kv[1], []byte("ms"))), 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case bytes.Equal(kv[0], railsViewsKey):
+			ar.ViewTime = ms
+		case bytes.Equal(kv[0], railsActiveRecordKey):
+			ar.DbTime = ms
+		}
+	}
+}
+
+type pendingRailsRequest struct {
+	request appRequestMsg
+	started time.Time
+}
+
+// RailsRequests follows a Rails request from its Started and Processing lines
+// to its Completed line. Requests are matched by request id if the app tags
+// its lines with one. Otherwise they can only be matched by dyno, which is
+// only safe on dynos serving one request at a time, as the tenant's workers
+// tell us.
+type RailsRequests struct {
+	sync.Mutex
+	pending map[string]*pendingRailsRequest
+}
+
+func NewRailsRequests() *RailsRequests {
+	return &RailsRequests{pending: make(map[string]*pendingRailsRequest)}
+}
+
+// Looks at a user line and returns the finished request if it was a
+// Completed line we saw the start of. The second return value reports whether
+// the line was a Rails request line at all.
+func (r *RailsRequests) Observe(drain, dyno string, msg []byte) (*appRequestMsg, bool) {
+	tags, line := stripLogTags(msg)
+
+	key := ""
+	for _, tag := range tags {
+		if looksLikeRequestId(tag) {
+			key = drain + " " + string(tag)
+		}
+	}
+	// Lines of concurrent requests interleave on a dyno
+	if key == "" && tenantConfigs.For(drain).Workers[dynoType(dyno)] == 1 {
+		key = drain + " " + dyno
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if m := railsStarted.FindSubmatch(line); m != nil {
+		if key == "" {
+			return nil, true
+		}
+		r.add(key, &appRequestMsg{Method: string(m[1]), Path: string(m[2])})
+		return nil, true
+	}
+
+	if m := railsProcessing.FindSubmatch(line); m != nil {
+		if key == "" {
+			return nil, true
+		}
+		pending, ok := r.pending[key]
+		if !ok {
+			pending = r.add(key, &appRequestMsg{})
+		}
+		pending.request.Controller = string(m[1])
+		pending.request.Action = string(m[2])
+		pending.request.Format = string(m[3])
+		return nil, true
+	}
+
+	if m := railsCompleted.FindSubmatch(line); m != nil {
+		// Without its start we don't know what the request was for
+		pending, ok := r.pending[key]
+		if !ok {
+			return nil, true
+		}
+		delete(r.pending, key)
+
+		ar := pending.request
+		ar.Framework = FrameworkRails
+		ar.Dyno = dyno
+		ar.Status, _ = strconv.Atoi(string(m[1]))
+		ar.Duration, _ = strconv.ParseFloat(string(m[2]), 64)
+		if len(m[3]) > 0 {
+			parseRailsBreakdown(m[3], &ar)
+		}
+		return &ar, true
+	}
+
+	return nil, false
+}
+
+// Must be called with the lock held.
+func (r *RailsRequests) add(key string, request *appRequestMsg) *pendingRailsRequest {
+	now := time.Now()
+
+	// Requests whose Completed line we never saw
+	if len(r.pending) >= railsPendingCapacity {
+		for k, pending := range r.pending {
+			if now.Sub(pending.started) > time.Minute {
+				delete(r.pending, k)
+			}
+		}
+	}
+
+	pending := &pendingRailsRequest{request: *request, started: now}
+	if len(r.pending) < railsPendingCapacity {
+		r.pending[key] = pending
+	}
+	return pending
+}
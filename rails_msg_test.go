@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRailsRequests(t *testing.T) {
+	requests := NewRailsRequests()
+	lines := []string{
+		`[1f3ed8a9-c80c-49de-a4af-2df9f4ddb858] Started GET "/users/1" for 10.1.2.3 at 2014-07-02 16:52:38 +0000`,
+		`[1f3ed8a9-c80c-49de-a4af-2df9f4ddb858] Processing by UsersController#show as HTML`,
+		`[1f3ed8a9-c80c-49de-a4af-2df9f4ddb858] Completed 200 OK in 123ms (Views: 40.1ms | ActiveRecord: 60.2ms)`,
+	}
+
+	var ar *appRequestMsg
+	for _, line := range lines {
+		var ok bool
+		ar, ok = requests.Observe("d.1", "web.1", []byte(line))
+		if !ok {
+			t.Fatalf("Expected %q to be recognized", line)
+		}
+	}
+
+	if ar == nil {
+		t.Fatalf("Expected the Completed line to finish the request")
+	}
+	if ar.Controller != "UsersController" || ar.Action != "show" || ar.Method != "GET" || ar.Path != "/users/1" {
+		t.Errorf("Unexpected request %+v", *ar)
+	}
+	if ar.Status != 200 || ar.Duration != 123 || ar.ViewTime != 40.1 || ar.DbTime != 60.2 {
+		t.Errorf("Unexpected timings %+v", *ar)
+	}
+
+	if _, ok := requests.Observe("d.1", "web.1", []byte("Rendered users/show.html.erb")); ok {
+		t.Errorf("Rendered lines should not be recognized")
+	}
+}
+
+func TestRailsRequestsInterleaved(t *testing.T) {
+	requests := NewRailsRequests()
+	observe := func(line string) *appRequestMsg {
+		ar, ok := requests.Observe("d.1", "web.1", []byte(line))
+		if !ok {
+			t.Fatalf("Expected %q to be recognized", line)
+		}
+		return ar
+	}
+
+	observe(`[1f3ed8a9-c80c-49de-a4af-2df9f4ddb858] Started GET "/users/1" for 10.1.2.3 at 2014-07-02 16:52:38 +0000`)
+	observe(`[2a4fe9b0-d91d-4aef-b5b0-3ea0a5eecb69] Started POST "/orders" for 10.1.2.3 at 2014-07-02 16:52:38 +0000`)
+	observe(`[2a4fe9b0-d91d-4aef-b5b0-3ea0a5eecb69] Processing by OrdersController#create as JSON`)
+	observe(`[1f3ed8a9-c80c-49de-a4af-2df9f4ddb858] Processing by UsersController#show as HTML`)
+
+	if ar := observe(`[1f3ed8a9-c80c-49de-a4af-2df9f4ddb858] Completed 200 OK in 123ms`); ar == nil || ar.Controller != "UsersController" || ar.Path != "/users/1" {
+		t.Errorf("Expected the request to be matched by request id, got %+v", ar)
+	}
+	if ar := observe(`[2a4fe9b0-d91d-4aef-b5b0-3ea0a5eecb69] Completed 201 Created in 45ms`); ar == nil || ar.Controller != "OrdersController" || ar.Method != "POST" {
+		t.Errorf("Expected the request to be matched by request id, got %+v", ar)
+	}
+
+	// Without request ids lines of concurrent requests can't be told apart
+	observe(`Started GET "/users/1" for 10.1.2.3 at 2014-07-02 16:52:38 +0000`)
+	if ar := observe(`Completed 200 OK in 123ms`); ar != nil {
+		t.Errorf("Expected untagged requests not to be matched by dyno, got %+v", ar)
+	}
+}
+
+func TestRailsRequestsSingleThreaded(t *testing.T) {
+	configs, err := parseTenantConfigs([]byte(`{"d.1": {"workers": {"web": 1}}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	defer func(c TenantConfigs) { tenantConfigs = c }(tenantConfigs)
+	tenantConfigs = configs
+
+	requests := NewRailsRequests()
+	requests.Observe("d.1", "web.1", []byte(`Started GET "/users/1" for 10.1.2.3 at 2014-07-02 16:52:38 +0000`))
+	requests.Observe("d.1", "web.1", []byte(`Processing by UsersController#show as HTML`))
+	if ar, _ := requests.Observe("d.1", "web.1", []byte(`Completed 200 OK in 123ms`)); ar == nil || ar.Controller != "UsersController" {
+		t.Errorf("Expected requests on a single threaded dyno to be matched by dyno, got %+v", ar)
+	}
+
+	if ar, ok := requests.Observe("d.1", "web.1", []byte(`Completed 200 OK in 123ms`)); !ok || ar != nil {
+		t.Errorf("Expected a Completed line without a start to be recognized and skipped, got %+v", ar)
+	}
+}
+
+func TestDrainRailsRequestRoute(t *testing.T) {
+	group := postToDrain(t, "d.rails-test",
+		`<190>1 2014-07-02T16:52:38.123456+00:00 host app web.1 - [1f3ed8a9-c80c-49de-a4af-2df9f4ddb858] Started GET "/users/42?page=2" for 10.1.2.3 at 2014-07-02 16:52:38 +0000`,
+		`<190>1 2014-07-02T16:52:38.223456+00:00 host app web.1 - [1f3ed8a9-c80c-49de-a4af-2df9f4ddb858] Completed 200 OK in 100ms`,
+	)
+
+	if n := len(group.AppRequests); n != 1 {
+		t.Fatalf("Got %d app requests, expected 1", n)
+	}
+	if ar := <-group.AppRequests; ar.Route != "/users/:id" || ar.Dyno != "web.1" {
+		t.Errorf("Expected the path to be normalized to /users/:id, got %+v", ar)
+	}
+}
+
+func TestRackRequest(t *testing.T) {
+	ar, ok := parseBytesToRackRequest([]byte(`10.1.2.3 - - [02/Jul/2014:16:52:38 +0000] "GET /users/1?page=2 HTTP/1.1" 404 1234 0.0123`))
+	if !ok {
+		t.Fatalf("Expected the common log line to be recognized")
+	}
+	if ar.Method != "GET" || ar.Path != "/users/1?page=2" || ar.Status != 404 || ar.Duration != 12.3 {
+		t.Errorf("Unexpected request %+v", ar)
+	}
+}
+
+func TestSidekiqJob(t *testing.T) {
+	testCases := map[string]sidekiqJobMsg{
+		"2014-07-02T16:52:38.123Z 4 TID-ox1abc HardWorker JID-1234abcd INFO: done: 1.234 sec": {Worker: "HardWorker", Jid: "1234abcd", Status: "done", Duration: 1.234},
+		"pid=4 tid=ox1abc class=HardWorker jid=1234abcd elapsed=0.5 INFO: fail":               {Worker: "HardWorker", Jid: "1234abcd", Status: "fail", Duration: 0.5},
+	}
+
+	for line, expected := range testCases {
+		sj, ok := parseBytesToSidekiqJob([]byte(line), parseAppFields([]byte(line)))
+		if !ok {
+			t.Errorf("Expected %q to be recognized", line)
+			continue
+		}
+		if sj != expected {
+			t.Errorf("Parsing %q yielded %+v, expected %+v", line, sj, expected)
+		}
+	}
+}
+
+func TestDrainRackRequestRoute(t *testing.T) {
+	group := postToDrain(t, "d.rack-test",
+		`<190>1 2014-07-02T16:52:38.123456+00:00 host app web.1 - 10.1.2.3 - - [02/Jul/2014:16:52:38 +0000] "GET /users/42?page=2 HTTP/1.1" 200 1234 0.0123`,
+	)
+
+	if n := len(group.AppRequests); n != 1 {
+		t.Fatalf("Got %d app requests, expected 1", n)
+	}
+	if ar := <-group.AppRequests; ar.Route != "/users/:id" || ar.Dyno != "web.1" {
+		t.Errorf("Expected the path to be normalized to /users/:id, got %+v", ar)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	deploySentinel  = []byte("Deploy ")
+	releaseSentinel = []byte("Release ")
+)
+
+const apiProcid = "api"
+
+const (
+	ReleaseKindDeploy  = "deploy"
+	ReleaseKindRelease = "release"
+)
+
+// Deploy 1a2b3c4 by user@example.com
+// Release v42 created by user@example.com
+//
+// Emitted by the api process of the app being drained.
+type releaseMsg struct {
+	Kind    string
+	Version string
+	Commit  string
+	User    string
+	Message string
+
+	timestamp   int64
+	sourceDrain string
+}
+
+func isReleaseMsg(pid string, msg []byte) bool {
+	return pid == apiProcid && (bytes.HasPrefix(msg, deploySentinel) || bytes.HasPrefix(msg, releaseSentinel))
+}
+
+func parseBytesToReleaseMsg(msg []byte) releaseMsg {
+	rm := releaseMsg{Message: string(bytes.TrimSpace(msg))}
+	fields := strings.Fields(string(msg))
+	if len(fields) < 2 {
+		return rm
+	}
+
+	switch {
+	case bytes.HasPrefix(msg, deploySentinel):
+		rm.Kind = ReleaseKindDeploy
+		rm.Commit = fields[1]
+	case bytes.HasPrefix(msg, releaseSentinel):
+		rm.Kind = ReleaseKindRelease
+		rm.Version = fields[1]
+	}
+
+	// Whoever did it comes right after the last "by"
+	for i := len(fields) - 2; i > 0; i-- {
+		if fields[i] == "by" {
+			rm.User = fields[i+1]
+			break
+		}
+	}
+	return rm
+}
+
+type releaseInfo struct {
+	Version string
+	Commit  string
+	User    string
+	At      time.Time
+}
+
+// ReleaseTracker remembers the release each drain is currently running, so
+// events can be tagged with it.
+type ReleaseTracker struct {
+	sync.RWMutex
+	drains map[string]*releaseInfo
+}
+
+func NewReleaseTracker() *ReleaseTracker {
+	return &ReleaseTracker{drains: make(map[string]*releaseInfo)}
+}
+
+// Records rm. A deploy line carries the commit of the release line that
+// follows it, so the commit is kept until the release comes in.
+func (t *ReleaseTracker) Observe(rm *releaseMsg) {
+	t.Lock()
+	defer t.Unlock()
+
+	info, ok := t.drains[rm.sourceDrain]
+	if !ok {
+		info = &releaseInfo{}
+		t.drains[rm.sourceDrain] = info
+	}
+
+	switch rm.Kind {
+	case ReleaseKindDeploy:
+		info.Commit = rm.Commit
+	case ReleaseKindRelease:
+		info.Version = rm.Version
+		info.User = rm.User
+		info.At = time.Unix(0, rm.timestamp*int64(time.Microsecond))
+		if rm.Commit == "" {
+			rm.Commit = info.Commit
+		}
+		// Later releases, like config changes, don't ship that commit
+		info.Commit = ""
+	}
+}
+
+// Returns the release version drain is running, or "" if we haven't seen one.
+func (t *ReleaseTracker) Current(drain string) string {
+	t.RLock()
+	defer t.RUnlock()
+
+	if info, ok := t.drains[drain]; ok {
+		return info.Version
+	}
+	return ""
+}
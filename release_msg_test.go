@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseReleaseMsg(t *testing.T) {
+	testCases := map[string]releaseMsg{
+		"Deploy 1a2b3c4 by user@example.com": {
+			Kind: ReleaseKindDeploy, Commit: "1a2b3c4", User: "user@example.com",
+		},
+		"Release v42 created by user@example.com": {
+			Kind: ReleaseKindRelease, Version: "v42", User: "user@example.com",
+		},
+	}
+
+	for msg, expected := range testCases {
+		if !isReleaseMsg(apiProcid, []byte(msg)) {
+			t.Errorf("%q should be a release message", msg)
+		}
+		rm := parseBytesToReleaseMsg([]byte(msg))
+		if rm.Kind != expected.Kind || rm.Version != expected.Version || rm.Commit != expected.Commit || rm.User != expected.User {
+			t.Errorf("Parsing %q yielded %+v, expected %+v", msg, rm, expected)
+		}
+	}
+
+	if isReleaseMsg("web.1", []byte("Release v42 created by user@example.com")) {
+		t.Errorf("Only the api process reports releases")
+	}
+}
+
+func TestDrainReleaseMsgs(t *testing.T) {
+	token := "d.release-test"
+	group := postToDrain(t, token,
+		"<190>1 2014-07-02T16:52:38.123456+00:00 host app api - Deploy 1a2b3c4 by user@example.com",
+		"<190>1 2014-07-02T16:52:39.123456+00:00 host app api - Release v5 created by user@example.com",
+		"<190>1 2014-07-02T16:53:39.123456+00:00 host app api - Release v6 created by user@example.com",
+	)
+
+	if n := len(group.ReleaseMsgs); n != 3 {
+		t.Fatalf("Got %d release messages, expected 3", n)
+	}
+	deploy, release, config := <-group.ReleaseMsgs, <-group.ReleaseMsgs, <-group.ReleaseMsgs
+	if deploy.Kind != ReleaseKindDeploy || deploy.Commit != "1a2b3c4" || deploy.sourceDrain != token {
+		t.Errorf("Unexpected deploy %+v", deploy)
+	}
+	if release.Kind != ReleaseKindRelease || release.Version != "v5" || release.Commit != "1a2b3c4" {
+		t.Errorf("Unexpected release %+v", release)
+	}
+	if release.Message != "Release v5 created by user@example.com" {
+		t.Errorf("Unexpected message %q", release.Message)
+	}
+	if config.Version != "v6" || config.Commit != "" {
+		t.Errorf("Expected the deploy's commit to go to its release only, got %+v", config)
+	}
+	if version := releaseTracker.Current(token); version != "v6" {
+		t.Errorf("Drain is running %q, expected v6", version)
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 	"log"
+	"math"
 	"time"
 	"strconv"
+	"strings"
 
 	"github.com/amir/raidman"
 	"github.com/heroku/slog"
@@ -39,19 +41,24 @@ func (p *RiemannPoster) Run() {
 				break
 			}
 
-			// Method    string
-			// Path      string
-			// Host      string
-			// RequestId string
-			// Fwd       string
-			// Dyno      string
-			// Connect   int
-			// Service   int
-			// Status    int
-			// Bytes     int
+			// Method     string
+			// Path       string
+			// Host       string
+			// RequestId  string
+			// Fwd        string
+			// Dyno       string
+			// Connect    float64
+			// Service    float64
+			// Status     int
+			// Bytes      int
+			// Protocol   string
+			// Tls        bool
+			// TlsVersion string
+			// Route      string
 
 			// timestamp int64
 			// sourceDrain string
+			// release string
 
 			event := &raidman.Event{
 				State:			 "ok",
@@ -60,19 +67,24 @@ func (p *RiemannPoster) Run() {
 				Metric:      rm.Connect + rm.Service,
 				Ttl:         300,
 				Time:        rm.timestamp / 1e6,
-				Description: fmt.Sprintf("%s %s in %dms by %s\n\nHost: %s\nRequest: %s", rm.Method, rm.Path, rm.Service, rm.Dyno, rm.Host, rm.RequestId),
+				Description: fmt.Sprintf("%s %s in %gms by %s\n\nHost: %s\nRequest: %s", rm.Method, rm.Path, rm.Service, rm.Dyno, rm.Host, rm.RequestId),
 				Attributes: map[string]string{
 					"method": rm.Method,
 					"path":   rm.Path,
+					"route":  rm.Route,
 					"status": strconv.Itoa(rm.Status),
 
 					"request_host": rm.Host,
 					"request_id":   rm.RequestId,
 					"fwd":          rm.Fwd,
 					"dyno":         rm.Dyno,
-					"bytes":        string(rm.Bytes),
+					"bytes":        strconv.Itoa(rm.Bytes),
+					"protocol":     rm.Protocol,
+					"tls":          strconv.FormatBool(rm.Tls),
+					"tls_version":  rm.TlsVersion,
 
 					"logplex_source_id": rm.sourceDrain,
+					"release":           rm.release,
 				},
 			}
 
@@ -91,15 +103,20 @@ func (p *RiemannPoster) Run() {
 			// Fwd       string
 			// Dyno      string
 			// Path      string
-			// RequestId string
-			// Connect   int
-			// Service   int
-			// Status    int
-			// Bytes     int
-			// Sock      string
+			// RequestId  string
+			// Connect    float64
+			// Service    float64
+			// Status     int
+			// Bytes      int
+			// Sock       string
+			// Protocol   string
+			// Tls        bool
+			// TlsVersion string
+			// Route      string
 
 			// timestamp int64
 			// sourceDrain string
+			// release string
 
 			event := &raidman.Event{
 				State:       "error",
@@ -112,17 +129,22 @@ func (p *RiemannPoster) Run() {
 					"at":     re.At,
 					"method": re.Method,
 					"path":   re.Path,
+					"route":  re.Route,
 					"status": strconv.Itoa(re.Status),
 
 					"host":       re.Host,
 					"request_id": re.RequestId,
 					"fwd":        re.Fwd,
 					"dyno":       re.Dyno,
-					"bytes":      strconv.Itoa(re.Status),
+					"bytes":      strconv.Itoa(re.Bytes),
 
-					"sock":      string(re.Sock),
+					"sock":        string(re.Sock),
+					"protocol":    re.Protocol,
+					"tls":         strconv.FormatBool(re.Tls),
+					"tls_version": re.TlsVersion,
 
 					"logplex_source_id": re.sourceDrain,
+					"release":           re.release,
 				},
 			}
 
@@ -144,9 +166,10 @@ func (p *RiemannPoster) Run() {
 
 			// timestamp int64
 			// sourceDrain string
+			// release string
 
-			// TODO: this breaks for 2X dynos
-			memload := dm.MemoryTotal / 512.0
+			// TODO: this breaks for apps mixing dyno sizes
+			memload := dm.MemoryTotal / MemoryQuota
 
 			state := "ok"
 			if memload > 0.8 {
@@ -168,6 +191,7 @@ func (p *RiemannPoster) Run() {
 				),
 				Attributes: map[string]string{
 					"logplex_source_id": dm.sourceDrain,
+					"release":           dm.release,
 					"dyno":              dm.Dyno,
 				},
 			}
@@ -183,12 +207,41 @@ func (p *RiemannPoster) Run() {
 				Description: fmt.Sprintf("%d page ins, %d page outs", dm.MemoryPgpgin, dm.MemoryPgpgout),
 				Attributes: map[string]string{
 					"logplex_source_id": dm.sourceDrain,
+					"release":           dm.release,
 					"dyno":              dm.Dyno,
 				},
 			}
 
 			p.deliver(event2)
 
+			if dm.trendSamples > 0 {
+				description := fmt.Sprintf("%.1fMB per hour over %d samples", dm.growthRate*3600, dm.trendSamples)
+				timeToQuota := ""
+				if dm.timeToQuota >= 0 {
+					description += fmt.Sprintf(", %s quota reached in %s",
+						ByteSize(MemoryQuota*1e6).String(), dm.timeToQuota-dm.timeToQuota%time.Second)
+					timeToQuota = strconv.FormatFloat(dm.timeToQuota.Seconds(), 'f', 0, 64)
+				}
+
+				event3 := &raidman.Event{
+					Host:        RiemannPrefix + dm.Source,
+					Service:     "memory growth",
+					Ttl:         300,
+					Time:        dm.timestamp / 1e6,
+					Metric:      dm.growthRate * 3600,
+					State:       memoryTrendState(dm.timeToQuota),
+					Description: description,
+					Attributes: map[string]string{
+						"logplex_source_id": dm.sourceDrain,
+						"release":           dm.release,
+						"dyno":              dm.Dyno,
+						"time_to_quota":     timeToQuota,
+					},
+				}
+
+				p.deliver(event3)
+			}
+
 		case dl, open := <-p.chanGroup.DynoLoadMsgs:
 			if !open {
 				break
@@ -202,6 +255,7 @@ func (p *RiemannPoster) Run() {
 
 			// timestamp int64
 			// sourceDrain string
+			// release string
 
 			state := "ok"
 			if dl.LoadAvg1Min > 0.8 {
@@ -222,7 +276,796 @@ func (p *RiemannPoster) Run() {
 				),
 				Attributes: map[string]string{
 					"logplex_source_id": dl.sourceDrain,
+					"release":           dl.release,
+					"dyno":              dl.Dyno,
+				},
+			}
+
+			p.deliver(event)
+
+		case dl, open := <-p.chanGroup.DynoLifecycleMsgs:
+			if !open {
+				break
+			}
+
+			// Dyno       string
+			// State      string
+			// PrevState  string
+			// Command    string
+			// ExitStatus int
+			// Event      string
+			// Message    string
+			// BootTime   time.Duration
+
+			// timestamp int64
+			// sourceDrain string
+			// release string
+
+			// Boot time per dyno and per process type
+			if dl.BootTime > 0 {
+				timeout := bootTimeout(dl.sourceDrain, dynoType(dl.Dyno))
+				description := fmt.Sprintf("%s booted in %.1fs", dl.Dyno, dl.BootTime.Seconds())
+				if timeout > 0 {
+					description += fmt.Sprintf(" (timeout at %.0fs)", timeout.Seconds())
+				}
+				for _, host := range []string{dl.Dyno, dynoType(dl.Dyno)} {
+					event := &raidman.Event{
+						Host:        RiemannPrefix + host,
+						Service:     "boot time",
+						Ttl:         300,
+						Time:        dl.timestamp / 1e6,
+						Metric:      dl.BootTime.Seconds(),
+						State:       bootTimeState(dl.BootTime, timeout),
+						Description: description,
+						Attributes: map[string]string{
+							"logplex_source_id": dl.sourceDrain,
+							"release":           dl.release,
+							"dyno":              dl.Dyno,
+							"dyno_type":         dynoType(dl.Dyno),
+						},
+					}
+
+					p.deliver(event)
+				}
+			}
+
+			// Plain state changes are only interesting in the fleet view
+			if dl.Event == "" {
+				break
+			}
+
+			state := "ok"
+			switch dl.Event {
+			case DynoEventCrash:
+				state = "critical"
+			case DynoEventRestart:
+				state = "warning"
+			}
+
+			event := &raidman.Event{
+				Host:        RiemannPrefix + dl.Dyno,
+				Service:     "dyno " + dl.Event,
+				Ttl:         300,
+				Time:        dl.timestamp / 1e6,
+				Metric:      1,
+				State:       state,
+				Description: dl.Message,
+				Attributes: map[string]string{
+					"logplex_source_id": dl.sourceDrain,
+					"release":           dl.release,
 					"dyno":              dl.Dyno,
+					"dyno_type":         dynoType(dl.Dyno),
+					"state":             dl.State,
+					"prev_state":        dl.PrevState,
+					"exit_status":       strconv.Itoa(dl.ExitStatus),
+				},
+			}
+
+			p.deliver(event)
+
+		case rm, open := <-p.chanGroup.ReleaseMsgs:
+			if !open {
+				break
+			}
+
+			// Kind    string
+			// Version string
+			// Commit  string
+			// User    string
+			// Message string
+
+			// timestamp int64
+			// sourceDrain string
+
+			// Deploy markers, tagged so dashboards can draw them as annotations
+			event := &raidman.Event{
+				State:       "ok",
+				Host:        RiemannPrefix + "api",
+				Service:     rm.Kind,
+				Ttl:         300,
+				Time:        rm.timestamp / 1e6,
+				Tags:        []string{"annotation", rm.Kind},
+				Description: rm.Message,
+				Attributes: map[string]string{
+					"release": rm.Version,
+					"commit":  rm.Commit,
+					"user":    rm.User,
+
+					"logplex_source_id": rm.sourceDrain,
+				},
+			}
+
+			p.deliver(event)
+
+		case dr, open := <-p.chanGroup.DeployReports:
+			if !open {
+				break
+			}
+
+			description := fmt.Sprintf("%s after %s: p95 %.0fms -> %.0fms, 5xx %.2f%% -> %.2f%%, H errors %.2f%% -> %.2f%%, memory %.0fMB -> %.0fMB",
+				dr.Verdict, dr.Release,
+				dr.Before.LatencyP95, dr.After.LatencyP95,
+				dr.Before.Error5xx*100, dr.After.Error5xx*100,
+				dr.Before.HErrors*100, dr.After.HErrors*100,
+				dr.Before.MemoryMB, dr.After.MemoryMB,
+			)
+			for _, reason := range dr.Reasons {
+				description += "\n" + reason
+			}
+
+			event := &raidman.Event{
+				State:       verdictState(dr.Verdict),
+				Host:        RiemannPrefix + "api",
+				Service:     "deploy regression",
+				Metric:      ratio(dr.After.LatencyP95, dr.Before.LatencyP95),
+				Ttl:         3600,
+				Time:        time.Now().Unix(),
+				Tags:        []string{"annotation", "deploy"},
+				Description: description,
+				Attributes: map[string]string{
+					"release": dr.Release,
+					"verdict": dr.Verdict,
+
+					"logplex_source_id": dr.Drain,
+				},
+			}
+
+			p.deliver(event)
+
+		case pr, open := <-p.chanGroup.ProcessTypeRollups:
+			if !open {
+				break
+			}
+
+			attributes := map[string]string{
+				"logplex_source_id": pr.sourceDrain,
+				"dyno_type":         pr.ProcessType,
+				"dynos":             strconv.Itoa(pr.Dynos),
+			}
+
+			// Memory and load across the formation, if any dyno reported them
+			if pr.Dynos > 0 {
+				// TODO: this breaks for apps mixing dyno sizes, same as the per dyno memory event
+				memload := pr.MemoryP95 / MemoryQuota
+				state := "ok"
+				if memload > 0.8 {
+					state = "critical"
+				}
+
+				p.deliver(&raidman.Event{
+					Host:        RiemannPrefix + pr.ProcessType,
+					Service:     "memory p95",
+					Ttl:         300,
+					Time:        pr.timestamp / 1e6,
+					Metric:      memload,
+					State:       state,
+					Description: fmt.Sprintf("avg %.0fMB, p95 %.0fMB, max %.0fMB across %d dynos", pr.MemoryAvg, pr.MemoryP95, pr.MemoryMax, pr.Dynos),
+					Attributes:  attributes,
+				})
+
+				state = "ok"
+				if pr.LoadP95 > 0.8 {
+					state = "critical"
+				}
+
+				p.deliver(&raidman.Event{
+					Host:        RiemannPrefix + pr.ProcessType,
+					Service:     "load p95",
+					Ttl:         300,
+					Time:        pr.timestamp / 1e6,
+					Metric:      pr.LoadP95,
+					State:       state,
+					Description: fmt.Sprintf("avg %.2f, p95 %.2f, max %.2f across %d dynos", pr.LoadAvg, pr.LoadP95, pr.LoadMax, pr.Dynos),
+					Attributes:  attributes,
+				})
+			}
+
+			if pr.Requests > 0 {
+				p.deliver(&raidman.Event{
+					Host:        RiemannPrefix + pr.ProcessType,
+					Service:     "heroku latency p95",
+					Ttl:         300,
+					Time:        pr.timestamp / 1e6,
+					Metric:      pr.LatencyP95,
+					State:       "ok",
+					Description: fmt.Sprintf("%d requests, avg %.0fms, p95 %.0fms, max %.0fms", pr.Requests, pr.LatencyAvg, pr.LatencyP95, pr.LatencyMax),
+					Attributes:  attributes,
+				})
+			}
+
+		case do, open := <-p.chanGroup.DynoOutliers:
+			if !open {
+				break
+			}
+
+			event := &raidman.Event{
+				Host:    RiemannPrefix + do.Dyno,
+				Service: "outlier " + do.Metric,
+				Ttl:     300,
+				Time:    do.timestamp / 1e6,
+				Metric:  do.Score,
+				State:   "warning",
+				Description: fmt.Sprintf("%s %s is %.2f, median of %d %s dynos is %.2f",
+					do.Dyno, do.Metric, do.Value, do.Peers, do.ProcessType, do.PeerMedian),
+				Attributes: map[string]string{
+					"logplex_source_id": do.sourceDrain,
+					"dyno":              do.Dyno,
+					"dyno_type":         do.ProcessType,
+					"metric":            do.Metric,
+				},
+			}
+
+			p.deliver(event)
+
+		case cr, open := <-p.chanGroup.CorrelatedRequests:
+			if !open {
+				break
+			}
+
+			attributes := map[string]string{
+				"method":     cr.Method,
+				"path":       cr.Path,
+				"status":     strconv.Itoa(cr.Status),
+				"request_id": cr.RequestId,
+				"dyno":       cr.Dyno,
+				"app_lines":  strconv.Itoa(cr.AppLines),
+
+				"logplex_source_id": cr.sourceDrain,
+				"release":           cr.release,
+			}
+			for k, v := range cr.AppFields {
+				attributes["app_"+k] = v
+			}
+
+			description := fmt.Sprintf("%s %s %d in %gms by %s", cr.Method, cr.Path, cr.Status, cr.Service, cr.Dyno)
+			if controller, ok := cr.AppFields["controller"]; ok {
+				description += fmt.Sprintf("\n%s#%s", controller, cr.AppFields["action"])
+			}
+			if db, ok := cr.AppFields["db"]; ok {
+				description += fmt.Sprintf("\ndb %sms", db)
+			}
+
+			event := &raidman.Event{
+				State:       "ok",
+				Host:        RiemannPrefix + "router",
+				Service:     cr.Host + " request",
+				Metric:      cr.Connect + cr.Service,
+				Ttl:         300,
+				Time:        cr.timestamp / 1e6,
+				Description: description,
+				Attributes:  attributes,
+			}
+
+			p.deliver(event)
+
+		case ar, open := <-p.chanGroup.AppRequests:
+			if !open {
+				break
+			}
+
+			// Framework  string
+			// Controller string
+			// Action     string
+			// Format     string
+			// Method     string
+			// Path       string
+			// Route      string
+			// Dyno       string
+			// Status     int
+			// Duration   float64
+			// ViewTime   float64
+			// DbTime     float64
+
+			// timestamp int64
+			// sourceDrain string
+			// release string
+
+			// Rails knows the controller action, Rack only the path
+			endpoint := ar.Method + " " + ar.Route
+			if ar.Controller != "" {
+				endpoint = ar.Controller + "#" + ar.Action
+			}
+
+			state := "ok"
+			if ar.Status >= 500 {
+				state = "error"
+			}
+
+			attributes := map[string]string{
+				"framework":  ar.Framework,
+				"controller": ar.Controller,
+				"action":     ar.Action,
+				"format":     ar.Format,
+				"method":     ar.Method,
+				"path":       ar.Path,
+				"route":      ar.Route,
+				"status":     strconv.Itoa(ar.Status),
+				"dyno":       ar.Dyno,
+
+				"logplex_source_id": ar.sourceDrain,
+				"release":           ar.release,
+			}
+
+			event := &raidman.Event{
+				State:       state,
+				Host:        RiemannPrefix + ar.Framework,
+				Service:     endpoint + " latency",
+				Metric:      ar.Duration,
+				Ttl:         300,
+				Time:        ar.timestamp / 1e6,
+				Description: fmt.Sprintf("%s %d in %.1fms (views %.1fms, db %.1fms) on %s", endpoint, ar.Status, ar.Duration, ar.ViewTime, ar.DbTime, ar.Dyno),
+				Attributes:  attributes,
+			}
+
+			p.deliver(event)
+
+			// Where the time went, if the framework told us
+			if ar.Framework == FrameworkRails {
+				for _, breakdown := range []struct {
+					name string
+					time float64
+				}{{"view", ar.ViewTime}, {"db", ar.DbTime}} {
+					p.deliver(&raidman.Event{
+						State:      "ok",
+						Host:       RiemannPrefix + ar.Framework,
+						Service:    endpoint + " " + breakdown.name + " time",
+						Metric:     breakdown.time,
+						Ttl:        300,
+						Time:       ar.timestamp / 1e6,
+						Attributes: attributes,
+					})
+				}
+			}
+
+		case sj, open := <-p.chanGroup.SidekiqJobs:
+			if !open {
+				break
+			}
+
+			// Worker   string
+			// Jid      string
+			// Status   string
+			// Duration float64
+			// Dyno     string
+
+			// timestamp int64
+			// sourceDrain string
+			// release string
+
+			state := "ok"
+			if sj.Status == SidekiqJobFail {
+				state = "error"
+			}
+
+			event := &raidman.Event{
+				State:       state,
+				Host:        RiemannPrefix + "sidekiq",
+				Service:     sj.Worker + " duration",
+				Metric:      sj.Duration,
+				Ttl:         300,
+				Time:        sj.timestamp / 1e6,
+				Description: fmt.Sprintf("%s %s %s in %.3fs on %s", sj.Worker, sj.Jid, sj.Status, sj.Duration, sj.Dyno),
+				Attributes: map[string]string{
+					"worker": sj.Worker,
+					"jid":    sj.Jid,
+					"status": sj.Status,
+					"dyno":   sj.Dyno,
+
+					"logplex_source_id": sj.sourceDrain,
+					"release":           sj.release,
+				},
+			}
+
+			p.deliver(event)
+
+		case pm, open := <-p.chanGroup.PostgresMetrics:
+			if !open {
+				break
+			}
+
+			host := RiemannPrefix + pm.Source
+			attributes := map[string]string{
+				"addon": pm.Addon,
+
+				"logplex_source_id": pm.sourceDrain,
+			}
+			at := pm.timestamp / 1e6
+
+			p.deliverAddonMetric(host, "postgres db size", pm.DbSize, "ok",
+				fmt.Sprintf("%s in %d tables", ByteSize(pm.DbSize).String(), pm.Tables), at, attributes)
+			limit := postgresConnectionLimit(pm.sourceDrain)
+			p.deliverAddonMetric(host, "postgres connections", pm.ActiveConnections,
+				connectionState(pm.ActiveConnections, limit),
+				fmt.Sprintf("%d active, %d waiting, limit %d", pm.ActiveConnections, pm.WaitingConnections, limit), at, attributes)
+			if pm.IndexCacheHitRate > 0 || pm.TableCacheHitRate > 0 {
+				cacheHitRate := math.Min(pm.IndexCacheHitRate, pm.TableCacheHitRate)
+				p.deliverAddonMetric(host, "postgres cache hit rate", cacheHitRate, hitRateState(cacheHitRate),
+					fmt.Sprintf("index %.4f, table %.4f", pm.IndexCacheHitRate, pm.TableCacheHitRate), at, attributes)
+			}
+			p.deliverAddonMetric(host, "postgres iops", pm.ReadIops+pm.WriteIops, "ok",
+				fmt.Sprintf("%.1f read, %.1f write", pm.ReadIops, pm.WriteIops), at, attributes)
+			p.deliverAddonMetric(host, "postgres load", pm.LoadAvg1Min, "ok",
+				fmt.Sprintf("load %.2f", pm.LoadAvg1Min), at, attributes)
+			p.deliverAddonMetric(host, "postgres memory", pm.MemoryTotal-pm.MemoryFree, "ok",
+				fmt.Sprintf("%s of %s used, %s cached, %s by postgres",
+					ByteSize(pm.MemoryTotal-pm.MemoryFree).String(), ByteSize(pm.MemoryTotal).String(),
+					ByteSize(pm.MemoryCached).String(), ByteSize(pm.MemoryPostgres).String()), at, attributes)
+
+		case rm, open := <-p.chanGroup.RedisMetrics:
+			if !open {
+				break
+			}
+
+			host := RiemannPrefix + rm.Source
+			attributes := map[string]string{
+				"addon": rm.Addon,
+
+				"logplex_source_id": rm.sourceDrain,
+			}
+			at := rm.timestamp / 1e6
+
+			memoryPercentage := rm.MemoryPercentage
+			if memoryPercentage == 0 && rm.MemoryTotal > 0 {
+				memoryPercentage = rm.MemoryRedis / rm.MemoryTotal * 100
+			}
+
+			limit := redisConnectionLimit(rm.sourceDrain)
+			p.deliverAddonMetric(host, "redis connections", rm.ActiveConnections,
+				connectionState(rm.ActiveConnections, limit),
+				fmt.Sprintf("%d active, limit %d", rm.ActiveConnections, limit), at, attributes)
+			p.deliverAddonMetric(host, "redis memory", memoryPercentage, memoryPercentageState(memoryPercentage),
+				fmt.Sprintf("%.1f%% used, %s by redis", memoryPercentage, ByteSize(rm.MemoryRedis).String()), at, attributes)
+			if rm.HitRate > 0 {
+				p.deliverAddonMetric(host, "redis hit rate", rm.HitRate, "ok",
+					fmt.Sprintf("hit rate %.4f", rm.HitRate), at, attributes)
+			}
+			p.deliverAddonMetric(host, "redis evicted keys", rm.EvictedKeys, "ok",
+				fmt.Sprintf("%d keys evicted", rm.EvictedKeys), at, attributes)
+			p.deliverAddonMetric(host, "redis iops", rm.ReadIops+rm.WriteIops, "ok",
+				fmt.Sprintf("%.1f read, %.1f write", rm.ReadIops, rm.WriteIops), at, attributes)
+			p.deliverAddonMetric(host, "redis load", rm.LoadAvg1Min, "ok",
+				fmt.Sprintf("load %.2f", rm.LoadAvg1Min), at, attributes)
+
+		case sq, open := <-p.chanGroup.SlowQueryRollups:
+			if !open {
+				break
+			}
+
+			event := &raidman.Event{
+				State:       "ok",
+				Host:        RiemannPrefix + "postgres",
+				Service:     "slow query " + sq.Fingerprint,
+				Metric:      sq.TotalTime,
+				Ttl:         300,
+				Time:        sq.timestamp / 1e6,
+				Description: fmt.Sprintf("%d calls, %.0fms total, p95 %.0fms, max %.0fms\n\n%s", sq.Count, sq.TotalTime, sq.P95, sq.Max, sq.Query),
+				Attributes: map[string]string{
+					"fingerprint": sq.Fingerprint,
+					"count":       strconv.Itoa(sq.Count),
+					"p95":         strconv.FormatFloat(sq.P95, 'f', 1, 64),
+					"max":         strconv.FormatFloat(sq.Max, 'f', 1, 64),
+
+					"logplex_source_id": sq.sourceDrain,
+				},
+			}
+
+			p.deliver(event)
+
+		case eg, open := <-p.chanGroup.ExceptionGroups:
+			if !open {
+				break
+			}
+
+			description := eg.Class
+			if len(eg.Samples) > 0 {
+				description += ": " + eg.Samples[0]
+			}
+			if len(eg.Frames) > 0 {
+				description += "\n\n" + strings.Join(eg.Frames, "\n")
+			}
+
+			event := &raidman.Event{
+				State:       "warning",
+				Host:        RiemannPrefix + "exceptions",
+				Service:     "new exception " + eg.Class,
+				Metric:      eg.Count,
+				Ttl:         300,
+				Time:        eg.timestamp / 1e6,
+				Description: description,
+				Attributes: map[string]string{
+					"fingerprint": eg.Fingerprint,
+					"class":       eg.Class,
+					"dyno":        strings.Join(eg.Dynos, ","),
+
+					"logplex_source_id": eg.sourceDrain,
+					"release":           eg.release,
+				},
+			}
+
+			p.deliver(event)
+
+		case rm, open := <-p.chanGroup.RuleMatches:
+			if !open {
+				break
+			}
+
+			// Rule   string
+			// Action string
+			// State  string
+			// Value  float64
+			// Dyno   string
+			// Line   string
+
+			// timestamp int64
+			// sourceDrain string
+			// release string
+
+			state, description := "ok", ""
+			if rm.Action == RuleActionAlert {
+				state, description = rm.State, rm.Line
+			}
+
+			event := &raidman.Event{
+				State:       state,
+				Host:        RiemannPrefix + "rules",
+				Service:     rm.Rule,
+				Metric:      rm.Value,
+				Ttl:         300,
+				Time:        rm.timestamp / 1e6,
+				Description: description,
+				Attributes: map[string]string{
+					"action": rm.Action,
+					"dyno":   rm.Dyno,
+
+					"logplex_source_id": rm.sourceDrain,
+					"release":           rm.release,
+				},
+			}
+
+			p.deliver(event)
+
+		case lr, open := <-p.chanGroup.LogLevelRollups:
+			if !open {
+				break
+			}
+
+			at := lr.timestamp / 1e6
+			attributes := map[string]string{
+				"process_type":      lr.ProcessType,
+				"logplex_source_id": lr.sourceDrain,
+			}
+
+			for _, level := range allLogLevels {
+				p.deliver(&raidman.Event{
+					State:       "ok",
+					Host:        RiemannPrefix + "app",
+					Service:     lr.ProcessType + " log " + level,
+					Metric:      lr.Rates[level],
+					Ttl:         300,
+					Time:        at,
+					Description: fmt.Sprintf("%d %s lines out of %d", lr.Counts[level], level, lr.Lines),
+					Attributes:  attributes,
+				})
+			}
+
+			p.deliver(&raidman.Event{
+				State:       lr.State,
+				Host:        RiemannPrefix + "app",
+				Service:     lr.ProcessType + " log error rate",
+				Metric:      lr.ErrorRate,
+				Ttl:         300,
+				Time:        at,
+				Description: fmt.Sprintf("%.2f errors/s, usually %.2f errors/s", lr.ErrorRate, lr.Baseline),
+				Attributes:  attributes,
+			})
+
+		case er, open := <-p.chanGroup.EndpointRollups:
+			if !open {
+				break
+			}
+
+			endpoint := er.Method + " " + er.Route
+			at := er.timestamp / 1e6
+			attributes := map[string]string{
+				"request_host":      er.Host,
+				"method":            er.Method,
+				"route":             er.Route,
+				"requests":          strconv.Itoa(er.Requests),
+				"logplex_source_id": er.sourceDrain,
+			}
+
+			p.deliver(&raidman.Event{
+				State:       "ok",
+				Host:        RiemannPrefix + "router",
+				Service:     er.Host + " " + endpoint + " latency p95",
+				Metric:      er.LatencyP95,
+				Ttl:         300,
+				Time:        at,
+				Description: fmt.Sprintf("%d requests, avg %.0fms, p95 %.0fms, max %.0fms", er.Requests, er.LatencyAvg, er.LatencyP95, er.LatencyMax),
+				Attributes:  attributes,
+			})
+
+			p.deliver(&raidman.Event{
+				State:       "ok",
+				Host:        RiemannPrefix + "router",
+				Service:     er.Host + " " + endpoint + " error rate",
+				Metric:      ratio(float64(er.Errors), float64(er.Requests)),
+				Ttl:         300,
+				Time:        at,
+				Description: fmt.Sprintf("%d errors out of %d requests", er.Errors, er.Requests),
+				Attributes:  attributes,
+			})
+
+		case ss, open := <-p.chanGroup.SloStatuses:
+			if !open {
+				break
+			}
+
+			at := ss.timestamp / 1e6
+			attributes := map[string]string{
+				"slo":               ss.Name,
+				"objective":         strconv.FormatFloat(ss.Objective, 'f', -1, 64),
+				"logplex_source_id": ss.sourceDrain,
+			}
+
+			p.deliver(&raidman.Event{
+				State:       "ok",
+				Host:        RiemannPrefix + "slo",
+				Service:     ss.Name + " budget remaining",
+				Metric:      ss.BudgetRemaining,
+				Ttl:         300,
+				Time:        at,
+				Description: fmt.Sprintf("%d bad out of %d requests, %.1f%% of the error budget left", ss.BadRequests, ss.Requests, 100*ss.BudgetRemaining),
+				Attributes:  attributes,
+			})
+
+			p.deliver(&raidman.Event{
+				State:       ss.State,
+				Host:        RiemannPrefix + "slo",
+				Service:     ss.Name + " burn rate",
+				Metric:      ss.FastBurnRate,
+				Ttl:         300,
+				Time:        at,
+				Description: fmt.Sprintf("burning the error budget %.1fx over the last hour, %.1fx over the last 6 hours", ss.FastBurnRate, ss.SlowBurnRate),
+				Attributes:  attributes,
+			})
+
+		case ar, open := <-p.chanGroup.ApdexRollups:
+			if !open {
+				break
+			}
+
+			event := &raidman.Event{
+				State:       apdexState(ar.Score),
+				Host:        RiemannPrefix + "router",
+				Service:     ar.Host + " " + ar.Route + " apdex",
+				Metric:      ar.Score,
+				Ttl:         300,
+				Time:        ar.timestamp / 1e6,
+				Description: fmt.Sprintf("%d satisfied, %d tolerating, %d frustrated at T=%gms", ar.Satisfied, ar.Tolerating, ar.Frustrated, ar.T),
+				Attributes: map[string]string{
+					"request_host": ar.Host,
+					"route":        ar.Route,
+					"apdex_t":      strconv.FormatFloat(ar.T, 'f', -1, 64),
+
+					"logplex_source_id": ar.sourceDrain,
+				},
+			}
+
+			p.deliver(event)
+
+		case ae, open := <-p.chanGroup.AnomalyEvents:
+			if !open {
+				break
+			}
+
+			event := &raidman.Event{
+				State:       ae.State,
+				Host:        RiemannPrefix + "router",
+				Service:     ae.Host + " " + ae.Series + " anomaly",
+				Metric:      ae.Score,
+				Ttl:         300,
+				Time:        ae.timestamp / 1e6,
+				Description: fmt.Sprintf("%s %.3g, usually %.3g ± %.3g", ae.Series, ae.Value, ae.Mean, ae.StdDev),
+				Attributes: map[string]string{
+					"request_host": ae.Host,
+					"series":       ae.Series,
+					"value":        strconv.FormatFloat(ae.Value, 'f', -1, 64),
+
+					"logplex_source_id": ae.sourceDrain,
+				},
+			}
+
+			p.deliver(event)
+
+		case cr, open := <-p.chanGroup.ConcurrencyRollups:
+			if !open {
+				break
+			}
+
+			host, description := RiemannPrefix+cr.Dyno, fmt.Sprintf("%.1f requests in flight, %.1f req/s at %.0fms", cr.Concurrency, cr.Throughput, cr.ServiceAvg)
+			if cr.Dyno == "" {
+				host = RiemannPrefix + cr.ProcessType
+				description += fmt.Sprintf(" across %d dynos", cr.Dynos)
+			}
+			attributes := map[string]string{
+				"dyno":              cr.Dyno,
+				"dyno_type":         cr.ProcessType,
+				"requests":          strconv.Itoa(cr.Requests),
+				"workers":           strconv.Itoa(cr.Workers),
+				"logplex_source_id": cr.sourceDrain,
+			}
+
+			p.deliver(&raidman.Event{
+				State:       "ok",
+				Host:        host,
+				Service:     "concurrency",
+				Metric:      cr.Concurrency,
+				Ttl:         300,
+				Time:        cr.timestamp / 1e6,
+				Description: description,
+				Attributes:  attributes,
+			})
+
+			// Saturation only means something once the tenant told us the workers
+			if cr.Workers > 0 {
+				p.deliver(&raidman.Event{
+					State:       saturationState(cr.Saturation),
+					Host:        host,
+					Service:     "saturation",
+					Metric:      cr.Saturation,
+					Ttl:         300,
+					Time:        cr.timestamp / 1e6,
+					Description: fmt.Sprintf("%.1f of %d workers busy", cr.Concurrency, cr.Workers),
+					Attributes:  attributes,
+				})
+			}
+
+		case le, open := <-p.chanGroup.LogplexErrors:
+			if !open {
+				break
+			}
+
+			// Code    int
+			// Message string
+			// Dropped int
+
+			// timestamp int64
+			// sourceDrain string
+
+			event := &raidman.Event{
+				State:       "critical",
+				Host:        RiemannPrefix + "logplex",
+				Service:     "log loss",
+				Metric:      le.Dropped,
+				Ttl:         300,
+				Time:        le.timestamp / 1e6,
+				Description: le.Message,
+				Attributes: map[string]string{
+					"code":    "L" + strconv.Itoa(le.Code),
+					"dropped": strconv.Itoa(le.Dropped),
+
+					"logplex_source_id": le.sourceDrain,
 				},
 			}
 
@@ -232,6 +1075,19 @@ func (p *RiemannPoster) Run() {
 	}
 }
 
+func (p *RiemannPoster) deliverAddonMetric(host, service string, metric interface{}, state, description string, at int64, attributes map[string]string) {
+	p.deliver(&raidman.Event{
+		Host:        host,
+		Service:     service,
+		Ttl:         300,
+		Time:        at,
+		Metric:      metric,
+		State:       state,
+		Description: description,
+		Attributes:  attributes,
+	})
+}
+
 func (p *RiemannPoster) deliver(event *raidman.Event) {
 	ctx := slog.Context{}
 	defer func() { LogWithContext(ctx) }()
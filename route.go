@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Routes tracked per drain when MAX_ROUTES isn't set
+const defaultMaxRoutes = 500
+
+const (
+	routeOther = "other"
+
+	routePlaceholderId   = ":id"
+	routePlaceholderUuid = ":uuid"
+	routePlaceholderHex  = ":hex"
+)
+
+// A tenant's route, e.g. /users/:id/orders or /assets/*. Segments starting
+// with a colon match any one segment, a trailing * matches the rest of the
+// path.
+type routePattern struct {
+	pattern  string
+	segments []string
+	prefix   bool
+}
+
+func compileRoutePattern(pattern string) (*routePattern, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, errors.New("route " + pattern + " does not start with /")
+	}
+
+	segments := splitPath(pattern)
+	rp := &routePattern{pattern: pattern, segments: segments}
+	if len(segments) > 0 && segments[len(segments)-1] == "*" {
+		rp.segments, rp.prefix = segments[:len(segments)-1], true
+	}
+	for i, segment := range rp.segments {
+		if segment == "*" {
+			return nil, errors.New("route " + pattern + " has a * before its end")
+		}
+		if strings.HasPrefix(segment, ":") {
+			rp.segments[i] = ":"
+		}
+	}
+	return rp, nil
+}
+
+func (rp *routePattern) match(segments []string) bool {
+	if len(segments) < len(rp.segments) || (!rp.prefix && len(segments) != len(rp.segments)) {
+		return false
+	}
+	for i, segment := range rp.segments {
+		if segment != ":" && segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+func isUuid(segment string) bool {
+	return looksLikeRequestId([]byte(segment))
+}
+
+// Long enough hex strings with a digit in them are tokens, digests and
+// object ids rather than words like "cafe" or "deadbeef".
+func isHexId(segment string) bool {
+	if len(segment) < 8 || strings.IndexAny(segment, "0123456789") < 0 {
+		return false
+	}
+	for i := 0; i < len(segment); i++ {
+		if !isHexDigit(segment[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumber(segment string) bool {
+	for i := 0; i < len(segment); i++ {
+		if segment[i] < '0' || segment[i] > '9' {
+			return false
+		}
+	}
+	return len(segment) > 0
+}
+
+// Turns a request path into its route: the query string is dropped, the
+// first of the tenant's patterns to match is used and otherwise numeric, UUID
+// and hex segments are replaced by placeholders.
+// /users/123/orders/456?x=1 becomes /users/:id/orders/:id
+func normalizePath(path string, patterns []*routePattern) string {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+
+	segments := splitPath(path)
+	for _, rp := range patterns {
+		if rp.match(segments) {
+			return rp.pattern
+		}
+	}
+
+	for i, segment := range segments {
+		switch {
+		case isNumber(segment):
+			segments[i] = routePlaceholderId
+		case isUuid(segment):
+			segments[i] = routePlaceholderUuid
+		case isHexId(segment):
+			segments[i] = routePlaceholderHex
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// RouteNormalizer turns paths into routes and caps the number of distinct
+// routes per drain, folding the long tail into "other" so crawlers and path
+// segments it can't tell are ids don't blow up the number of series.
+type RouteNormalizer struct {
+	sync.Mutex
+	maxRoutes int
+	routes    map[string]map[string]bool
+}
+
+func NewRouteNormalizer(maxRoutes int) *RouteNormalizer {
+	if maxRoutes <= 0 {
+		maxRoutes = defaultMaxRoutes
+	}
+	return &RouteNormalizer{maxRoutes: maxRoutes, routes: make(map[string]map[string]bool)}
+}
+
+// Returns the route of path for drain.
+func (n *RouteNormalizer) Route(drain, path string) string {
+	if path == "" {
+		return ""
+	}
+	route := normalizePath(path, tenantConfigs.For(drain).routes)
+
+	n.Lock()
+	defer n.Unlock()
+
+	routes, ok := n.routes[drain]
+	if !ok {
+		routes = make(map[string]bool)
+		n.routes[drain] = routes
+	}
+	if !routes[route] {
+		if len(routes) >= n.maxRoutes {
+			return routeOther
+		}
+		routes[route] = true
+	}
+	return route
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNormalizePath(t *testing.T) {
+	var patterns []*routePattern
+	for _, pattern := range []string{"/users/:id/avatar", "/assets/*"} {
+		rp, err := compileRoutePattern(pattern)
+		if err != nil {
+			t.Fatalf("Unexpected error %q", err)
+		}
+		patterns = append(patterns, rp)
+	}
+
+	testCases := map[string]string{
+		"/users/123/orders/456?x=1":                          "/users/:id/orders/:id",
+		"/orders/1f3ed8a9-c80c-49de-a4af-2df9f4ddb858/items": "/orders/:uuid/items",
+		"/blobs/5d41402abc4b2a76b9719d911017c592":            "/blobs/:hex",
+		"/users/jane/avatar":                                 "/users/:id/avatar",
+		"/assets/application-1234.css":                       "/assets/*",
+		"/cafe/deadbeef/":                                    "/cafe/deadbeef",
+		"/":                                                  "/",
+	}
+	for path, expected := range testCases {
+		if route := normalizePath(path, patterns); route != expected {
+			t.Errorf("Normalized %q to %q, expected %q", path, route, expected)
+		}
+	}
+
+	if _, err := compileRoutePattern("/a/*/b"); err == nil {
+		t.Errorf("Expected a * before the end of a route to be rejected")
+	}
+}
+
+func TestRouteCardinality(t *testing.T) {
+	n := NewRouteNormalizer(2)
+	for _, tc := range [][2]string{{"/a", "/a"}, {"/b/1", "/b/:id"}, {"/c", routeOther}} {
+		if route := n.Route("d.1", tc[0]); route != tc[1] {
+			t.Errorf("Routed %q to %q, expected %q", tc[0], route, tc[1])
+		}
+	}
+	if route := n.Route("d.1", "/b/2"); route != "/b/:id" {
+		t.Errorf("Expected known routes to be kept past the cap, got %q", route)
+	}
+}
@@ -20,26 +20,41 @@ var (
 	keyStatus    = []byte("status")
 	keySock      = []byte("sock")
 	keyBytes     = []byte("bytes")
+	keyProtocol  = []byte("protocol")
+	keyTls       = []byte("tls")
+	keyTlsVer    = []byte("tls_version")
 	keyCodeH     = []byte("code=H")
 )
 
+// Router durations are whole milliseconds on older lines and fractional ones,
+// e.g. service=12.5ms, on newer ones.
+func parseRouterDuration(val []byte) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSuffix(string(val), "ms"), 64)
+}
+
 // at=info method=GET path=/check?metric=railgun.accepting:sum:max,railgun.running:sum:max&0
 // host=umpire.herokai.com request_id=1f3ed8a9-c80c-49de-a4af-2df9f4ddb858 fwd="46.20.45.18"
-// dyno=web.14 connect=1ms service=849ms status=500 bytes=306
+// dyno=web.14 connect=1ms service=849ms status=500 bytes=306 protocol=https tls=true
+// tls_version=TLSv1.3
 type routerMsg struct {
-	Method    string
-	Path      string
-	Host      string
-	RequestId string
-	Fwd       string
-	Dyno      string
-	Connect   int
-	Service   int
-	Status    int
-	Bytes     int
+	Method     string
+	Path       string
+	Host       string
+	RequestId  string
+	Fwd        string
+	Dyno       string
+	Connect    float64 // ms
+	Service    float64 // ms
+	Status     int
+	Bytes      int
+	Protocol   string
+	Tls        bool
+	TlsVersion string
+	Route      string // Path as a route, e.g. /users/:id
 
 	timestamp   int64
 	sourceDrain string
+	release     string
 }
 
 func (rm *routerMsg) HandleLogfmt(key, val []byte) error {
@@ -57,13 +72,13 @@ func (rm *routerMsg) HandleLogfmt(key, val []byte) error {
 	case bytes.Equal(key, keyDyno):
 		rm.Dyno = string(val)
 	case bytes.Equal(key, keyConnect):
-		connect, e := strconv.Atoi(strings.TrimSuffix(string(val), "ms"))
+		connect, e := parseRouterDuration(val)
 		if e != nil {
 			return e
 		}
 		rm.Connect = connect
 	case bytes.Equal(key, keyService):
-		service, e := strconv.Atoi(strings.TrimSuffix(string(val), "ms"))
+		service, e := parseRouterDuration(val)
 		if e != nil {
 			return e
 		}
@@ -80,6 +95,12 @@ func (rm *routerMsg) HandleLogfmt(key, val []byte) error {
 			return e
 		}
 		rm.Bytes = bytes
+	case bytes.Equal(key, keyProtocol):
+		rm.Protocol = string(val)
+	case bytes.Equal(key, keyTls):
+		rm.Tls = string(val) == "true"
+	case bytes.Equal(key, keyTlsVer):
+		rm.TlsVersion = string(val)
 	default:
 		return nil
 		// log.Printf("Unknown key (%s) with value: %s\n", key, string(val))
@@ -88,23 +109,28 @@ func (rm *routerMsg) HandleLogfmt(key, val []byte) error {
 }
 
 type routerError struct {
-	At        string
-	Code      string
-	Desc      string
-	Method    string
-	Host      string
-	Fwd       string
-	Dyno      string
-	Path      string
-	RequestId string
-	Connect   int
-	Service   int
-	Status    int
-	Bytes     int
-	Sock      string
+	At         string
+	Code       string
+	Desc       string
+	Method     string
+	Host       string
+	Fwd        string
+	Dyno       string
+	Path       string
+	RequestId  string
+	Connect    float64 // ms
+	Service    float64 // ms
+	Status     int
+	Bytes      int
+	Sock       string
+	Protocol   string
+	Tls        bool
+	TlsVersion string
+	Route      string // Path as a route, e.g. /users/:id
 
 	timestamp   int64
 	sourceDrain string
+	release     string
 }
 
 func (re *routerError) HandleLogfmt(key, val []byte) error {
@@ -128,11 +154,11 @@ func (re *routerError) HandleLogfmt(key, val []byte) error {
 	case bytes.Equal(key, keyDyno):
 		re.Dyno = string(val)
 	case bytes.Equal(key, keyConnect):
-		connect, _ := strconv.Atoi(strings.TrimSuffix(string(val), "ms"))
+		connect, _ := parseRouterDuration(val)
 		// swallow errors because connect could be nothing
 		re.Connect = connect
 	case bytes.Equal(key, keyService):
-		service, _ := strconv.Atoi(strings.TrimSuffix(string(val), "ms"))
+		service, _ := parseRouterDuration(val)
 		// swallow errors because service could be nothing
 		re.Service = service
 	case bytes.Equal(key, keyStatus):
@@ -145,6 +171,12 @@ func (re *routerError) HandleLogfmt(key, val []byte) error {
 		re.Bytes = bytes
 	case bytes.Equal(key, keySock):
 		re.Sock = string(val)
+	case bytes.Equal(key, keyProtocol):
+		re.Protocol = string(val)
+	case bytes.Equal(key, keyTls):
+		re.Tls = string(val) == "true"
+	case bytes.Equal(key, keyTlsVer):
+		re.TlsVersion = string(val)
 	default:
 		return nil
 		// log.Printf("Unknown key (%s) with value: %s\n", key, string(val))
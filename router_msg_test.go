@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kr/logfmt"
+)
+
+func TestRouterMsgNewerFields(t *testing.T) {
+	line := `at=info method=GET path="/users/1" host=example.herokuapp.com request_id=1f3ed8a9-c80c-49de-a4af-2df9f4ddb858 fwd="10.1.2.3" dyno=web.1 connect=0.5ms service=12.5ms status=200 bytes=1234 protocol=https tls=true tls_version=TLSv1.3`
+
+	var rm routerMsg
+	if err := logfmt.Unmarshal([]byte(line), &rm); err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	if rm.Connect != 0.5 || rm.Service != 12.5 || rm.Status != 200 || rm.Bytes != 1234 {
+		t.Errorf("Unexpected timings %+v", rm)
+	}
+	if rm.Protocol != "https" || !rm.Tls || rm.TlsVersion != "TLSv1.3" {
+		t.Errorf("Unexpected protocol %+v", rm)
+	}
+
+	var re routerError
+	if err := logfmt.Unmarshal([]byte(`at=error code=H12 desc="Request timeout" method=GET path="/" dyno=web.1 connect=1ms service=30000ms status=503 bytes=0 protocol=http`), &re); err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	if re.Service != 30000 || re.Protocol != "http" || re.Tls {
+		t.Errorf("Unexpected router error %+v", re)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/heroku/slog"
+)
+
+const (
+	RuleActionCount = "count"
+	RuleActionGauge = "gauge"
+	RuleActionTimer = "timer"
+	RuleActionAlert = "alert"
+)
+
+// A tenant's rule for lines none of the parsers recognize:
+//
+//	{"name": "payments.failed", "procid": "web", "contains": "payment failed", "action": "count"}
+//	{"name": "cache.miss", "regex": "cache miss key=\\S+ took ([\\d.]+)ms", "action": "timer"}
+//	{"name": "checkout.time", "contains": "checkout", "field": "duration", "action": "timer"}
+//
+// A line matches when it matches every matcher that is set. Gauges and timers
+// take their value from the field if one is set, which the line must have,
+// and otherwise from the "value" capture group of the regex, or its first one.
+type extractionRule struct {
+	Name     string `json:"name"`
+	App      string `json:"app"`    // syslog app name, e.g. "app" or "heroku"
+	Procid   string `json:"procid"` // "web" matches every web dyno, "web.1" only that one
+	Contains string `json:"contains"`
+	Regex    string `json:"regex"`
+	Field    string `json:"field"` // logfmt or JSON field, "http.status" for nested ones
+	Action   string `json:"action"`
+	State    string `json:"state"` // of alerts, "warning" by default
+
+	contains   []byte
+	regex      *regexp.Regexp
+	valueGroup int
+}
+
+// Checks the rule and prepares its matchers
+func (r *extractionRule) compile() error {
+	if r.Name == "" {
+		return errors.New("rule without a name")
+	}
+	if r.Contains == "" && r.Regex == "" && r.Field == "" {
+		return fmt.Errorf("rule %s: needs contains, regex or field", r.Name)
+	}
+
+	r.contains = []byte(r.Contains)
+	if r.Regex != "" {
+		regex, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return fmt.Errorf("rule %s: %s", r.Name, err)
+		}
+		r.regex = regex
+	}
+
+	switch r.Action {
+	case RuleActionCount:
+	case RuleActionGauge, RuleActionTimer:
+		if r.Field != "" {
+			break
+		}
+		if r.regex == nil || r.regex.NumSubexp() == 0 {
+			return fmt.Errorf("rule %s: %s needs a field or a regex with a capture group", r.Name, r.Action)
+		}
+		r.valueGroup = 1
+		for i, name := range r.regex.SubexpNames() {
+			if name == "value" {
+				r.valueGroup = i
+			}
+		}
+	case RuleActionAlert:
+		if r.State == "" {
+			r.State = "warning"
+		}
+	default:
+		return fmt.Errorf("rule %s: unknown action %q", r.Name, r.Action)
+	}
+	return nil
+}
+
+// Returns the value msg yields for the rule, and whether it matched at all.
+// fields are the logfmt or JSON fields of msg.
+func (r *extractionRule) match(app, procid string, msg []byte, fields map[string]string) (float64, bool) {
+	if r.App != "" && r.App != app {
+		return 0, false
+	}
+	if r.Procid != "" && r.Procid != procid && r.Procid != dynoType(procid) {
+		return 0, false
+	}
+	if len(r.contains) > 0 && !bytes.Contains(msg, r.contains) {
+		return 0, false
+	}
+	field, hasField := fields[r.Field]
+	if r.Field != "" && !hasField {
+		return 0, false
+	}
+
+	var m [][]byte
+	if r.regex != nil {
+		if m = r.regex.FindSubmatch(msg); m == nil {
+			return 0, false
+		}
+	}
+	if r.Action != RuleActionGauge && r.Action != RuleActionTimer {
+		return 1, true
+	}
+	if r.Field != "" {
+		value, err := strconv.ParseFloat(strings.TrimSuffix(field, "ms"), 64)
+		return value, err == nil
+	}
+	value, err := strconv.ParseFloat(string(m[r.valueGroup]), 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// A line that matched a tenant's rule
+type ruleMatch struct {
+	Rule   string
+	Action string
+	State  string
+	Value  float64
+	Dyno   string
+	Line   string
+
+	timestamp   int64
+	sourceDrain string
+	release     string
+}
+
+// Runs the rules of drain on a line none of the parsers recognized. fields
+// are the logfmt or JSON fields of msg. Returns whether any of them matched.
+func applyRules(ctx slog.Context, chanGroup *ChanGroup, drain, release, app, procid string, msg []byte, fields map[string]string, timestamp int64) bool {
+	matched := false
+	for _, rule := range tenantConfigs.For(drain).Rules {
+		value, ok := rule.match(app, procid, msg, fields)
+		if !ok {
+			continue
+		}
+		matched = true
+		ctx.Count("rules."+rule.Name, 1)
+
+		chanGroup.RuleMatches <- &ruleMatch{
+			Rule:        rule.Name,
+			Action:      rule.Action,
+			State:       rule.State,
+			Value:       value,
+			Dyno:        procid,
+			Line:        string(msg),
+			timestamp:   timestamp,
+			sourceDrain: drain,
+			release:     release,
+		}
+	}
+	return matched
+}
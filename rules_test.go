@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestExtractionRules(t *testing.T) {
+	configs, err := parseTenantConfigs([]byte(`{
+		"d.1": {"rules": [
+			{"name": "payments.failed", "procid": "web", "contains": "payment failed", "action": "count"},
+			{"name": "cache.miss", "regex": "cache miss key=\\S+ took (?P<value>[\\d.]+)ms", "action": "timer"},
+			{"name": "checkout.time", "contains": "checkout", "field": "http.duration_ms", "action": "timer"}
+		]},
+		"*": {"rules": [{"name": "oom", "contains": "Out of memory", "action": "alert"}]}
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+
+	rules := configs.For("d.1").Rules
+	if _, ok := rules[0].match("app", "web.1", []byte("payment failed for order 12"), nil); !ok {
+		t.Errorf("Expected the count rule to match")
+	}
+	if _, ok := rules[0].match("app", "worker.1", []byte("payment failed for order 12"), nil); ok {
+		t.Errorf("Expected the count rule not to match other process types")
+	}
+	if value, ok := rules[1].match("app", "web.1", []byte("cache miss key=user:1 took 2.5ms"), nil); !ok || value != 2.5 {
+		t.Errorf("Expected the timer rule to extract 2.5, got %f (%t)", value, ok)
+	}
+
+	fields := parseAppFields([]byte(`{"msg":"checkout done","http":{"duration_ms":"12.5"}}`))
+	if value, ok := rules[2].match("app", "web.1", []byte("checkout done"), fields); !ok || value != 12.5 {
+		t.Errorf("Expected the field rule to extract 12.5, got %f (%t)", value, ok)
+	}
+	if _, ok := rules[2].match("app", "web.1", []byte("checkout done"), nil); ok {
+		t.Errorf("Expected the field rule not to match lines without the field")
+	}
+
+	if rules := configs.For("d.2").Rules; len(rules) != 1 || rules[0].State != "warning" {
+		t.Errorf("Expected other drains to get the default alert rule, got %+v", rules)
+	}
+
+	for _, config := range []string{
+		`{"d.1": {"rules": [{"name": "x", "contains": "x", "action": "sum"}]}}`,
+		`{"d.1": {"rules": [{"name": "x", "contains": "x", "action": "gauge"}]}}`,
+		`{"d.1": {"rules": [{"name": "x", "regex": "(", "action": "count"}]}}`,
+	} {
+		if _, err := parseTenantConfigs([]byte(config)); err == nil {
+			t.Errorf("Expected %s to be rejected", config)
+		}
+	}
+}
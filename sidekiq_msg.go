@@ -0,0 +1,49 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+)
+
+const (
+	SidekiqJobDone = "done"
+	SidekiqJobFail = "fail"
+)
+
+var (
+	// 2014-07-02T16:52:38.123Z 4 TID-ox1abc HardWorker JID-1234abcd INFO: done: 1.234 sec
+	sidekiqClassic = regexp.MustCompile(`TID-\S+ (\S+) JID-(\S+)(?: \S+)* INFO: (done|fail): ([\d.]+) sec`)
+	// pid=4 tid=ox1abc class=HardWorker jid=1234abcd elapsed=1.234 INFO: done
+	sidekiqLogfmt = regexp.MustCompile(`INFO: (done|fail)\s*$`)
+)
+
+// A finished Sidekiq job
+type sidekiqJobMsg struct {
+	Worker   string
+	Jid      string
+	Status   string
+	Duration float64 // seconds
+	Dyno     string
+
+	timestamp   int64
+	sourceDrain string
+	release     string
+}
+
+// Parses the line Sidekiq logs when a job is done or failed, in either the
+// classic or the logfmt style. fields are the logfmt fields of msg.
+func parseBytesToSidekiqJob(msg []byte, fields map[string]string) (sidekiqJobMsg, bool) {
+	if m := sidekiqClassic.FindSubmatch(msg); m != nil {
+		sj := sidekiqJobMsg{Worker: string(m[1]), Jid: string(m[2]), Status: string(m[3])}
+		sj.Duration, _ = strconv.ParseFloat(string(m[4]), 64)
+		return sj, true
+	}
+
+	if m := sidekiqLogfmt.FindSubmatch(msg); m != nil && fields["class"] != "" && fields["elapsed"] != "" {
+		sj := sidekiqJobMsg{Worker: fields["class"], Jid: fields["jid"], Status: string(m[1])}
+		sj.Duration, _ = strconv.ParseFloat(fields["elapsed"], 64)
+		return sj, true
+	}
+
+	return sidekiqJobMsg{}, false
+}
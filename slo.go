@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSloPeriodDays = 30
+
+	// Burn rates, in multiples of the rate that exactly uses up the budget
+	// over the period, and the long and short windows they have to be
+	// exceeded over. A fast burn uses 2% of a 30 day budget in an hour, a
+	// slow one 5% in 6 hours. The short windows make alerts stop soon after
+	// the burning does.
+	sloFastBurnRate = 14.4
+	sloSlowBurnRate = 6
+
+	sloFastBurnLong  = time.Hour
+	sloFastBurnShort = 5 * time.Minute
+	sloSlowBurnLong  = 6 * time.Hour
+	sloSlowBurnShort = 30 * time.Minute
+)
+
+// A tenant's service level objective for an endpoint:
+//
+//	{"name": "api", "method": "GET", "route": "/api/*", "objective": 99.5, "latency_ms": 500}
+//
+// reads as 99.5% of GET /api/* requests are served in under 500ms and without
+// a 5xx or H code over the last 30 days.
+type serviceLevelObjective struct {
+	Name       string  `json:"name"`
+	Host       string  `json:"host"`
+	Method     string  `json:"method"`
+	Route      string  `json:"route"` // a route pattern, see routePattern
+	Objective  float64 `json:"objective"`
+	LatencyMs  float64 `json:"latency_ms"` // 0 for no latency objective
+	PeriodDays int     `json:"period_days"`
+
+	route *routePattern
+}
+
+// Checks the objective and prepares its route
+func (slo *serviceLevelObjective) compile() error {
+	if slo.Name == "" {
+		return errors.New("slo without a name")
+	}
+	if slo.Objective <= 0 || slo.Objective >= 100 {
+		return fmt.Errorf("slo %s: objective must be a percentage between 0 and 100", slo.Name)
+	}
+	if slo.PeriodDays == 0 {
+		slo.PeriodDays = defaultSloPeriodDays
+	}
+	if slo.PeriodDays < 0 {
+		return fmt.Errorf("slo %s: period_days must be positive", slo.Name)
+	}
+	if slo.Route != "" {
+		route, err := compileRoutePattern(slo.Route)
+		if err != nil {
+			return fmt.Errorf("slo %s: %s", slo.Name, err)
+		}
+		slo.route = route
+	}
+	return nil
+}
+
+func (slo *serviceLevelObjective) matches(host, method string, segments []string) bool {
+	return (slo.Host == "" || slo.Host == host) &&
+		(slo.Method == "" || strings.EqualFold(slo.Method, method)) &&
+		(slo.route == nil || slo.route.match(segments))
+}
+
+// Share of requests allowed to be bad
+func (slo *serviceLevelObjective) errorBudget() float64 {
+	return 1 - slo.Objective/100
+}
+
+type sloBucket struct {
+	index int64
+	total int
+	bad   int
+}
+
+// Request counts in fixed width time buckets, the oldest overwritten by the
+// newest.
+type sloBuckets struct {
+	width   time.Duration
+	buckets []sloBucket
+}
+
+func newSloBuckets(width time.Duration, n int) *sloBuckets {
+	return &sloBuckets{width: width, buckets: make([]sloBucket, n)}
+}
+
+func (b *sloBuckets) Add(at time.Time, bad bool) {
+	index := at.UnixNano() / int64(b.width)
+	bucket := &b.buckets[index%int64(len(b.buckets))]
+	if bucket.index > index {
+		// Too old to still have a bucket
+		return
+	}
+	if bucket.index < index {
+		*bucket = sloBucket{index: index}
+	}
+	bucket.total++
+	if bad {
+		bucket.bad++
+	}
+}
+
+// Returns the requests, and how many of them were bad, over the window ending
+// at now.
+func (b *sloBuckets) Sum(now time.Time, window time.Duration) (int, int) {
+	last := now.UnixNano() / int64(b.width)
+	first := last - int64(window/b.width) + 1
+	total, bad := 0, 0
+	for _, bucket := range b.buckets {
+		if bucket.index >= first && bucket.index <= last {
+			total += bucket.total
+			bad += bucket.bad
+		}
+	}
+	return total, bad
+}
+
+// Per minute counts for the burn rate windows and per hour ones for the
+// period
+type sloState struct {
+	slo     *serviceLevelObjective
+	minutes *sloBuckets
+	hours   *sloBuckets
+}
+
+func newSloState(slo *serviceLevelObjective) *sloState {
+	return &sloState{
+		slo:     slo,
+		minutes: newSloBuckets(time.Minute, int(sloSlowBurnLong/time.Minute)),
+		hours:   newSloBuckets(time.Hour, slo.PeriodDays*24),
+	}
+}
+
+// How fast the budget went over window, 1 being the rate that uses it up
+// exactly at the end of the period.
+func (s *sloState) burnRate(now time.Time, window time.Duration) float64 {
+	total, bad := s.minutes.Sum(now, window)
+	return ratio(ratio(float64(bad), float64(total)), s.slo.errorBudget())
+}
+
+// Where an objective stands
+type sloStatus struct {
+	Name            string  `json:"name"`
+	Objective       float64 `json:"objective"`
+	Requests        int     `json:"requests"`
+	BadRequests     int     `json:"bad_requests"`
+	BudgetRemaining float64 `json:"budget_remaining"` // share of the period's budget, negative once overspent
+	FastBurnRate    float64 `json:"fast_burn_rate"`   // over the last hour
+	SlowBurnRate    float64 `json:"slow_burn_rate"`   // over the last 6 hours
+	State           string  `json:"state"`
+
+	timestamp   int64
+	sourceDrain string
+}
+
+func (s *sloState) status(now time.Time) *sloStatus {
+	total, bad := s.hours.Sum(now, time.Duration(s.slo.PeriodDays)*24*time.Hour)
+	status := &sloStatus{
+		Name:            s.slo.Name,
+		Objective:       s.slo.Objective,
+		Requests:        total,
+		BadRequests:     bad,
+		BudgetRemaining: 1 - ratio(float64(bad), s.slo.errorBudget()*float64(total)),
+		FastBurnRate:    s.burnRate(now, sloFastBurnLong),
+		SlowBurnRate:    s.burnRate(now, sloSlowBurnLong),
+		State:           "ok",
+		timestamp:       now.UnixNano() / int64(time.Microsecond),
+	}
+
+	switch {
+	case status.FastBurnRate > sloFastBurnRate && s.burnRate(now, sloFastBurnShort) > sloFastBurnRate:
+		status.State = "critical"
+	case status.SlowBurnRate > sloSlowBurnRate && s.burnRate(now, sloSlowBurnShort) > sloSlowBurnRate:
+		status.State = "warning"
+	}
+	return status
+}
+
+// SloTracker evaluates the tenants' objectives against their router lines.
+type SloTracker struct {
+	sync.Mutex
+	drains map[string]map[*serviceLevelObjective]*sloState
+}
+
+func NewSloTracker() *SloTracker {
+	return &SloTracker{drains: make(map[string]map[*serviceLevelObjective]*sloState)}
+}
+
+// Records a request to path. latency is connect + service time in ms,
+// isError is set for router errors.
+func (t *SloTracker) ObserveRouter(drain, host, method, path string, latency float64, status int, isError bool, at time.Time) {
+	slos := tenantConfigs.For(drain).Slos
+	if len(slos) == 0 {
+		return
+	}
+
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	segments := splitPath(path)
+
+	t.Lock()
+	defer t.Unlock()
+
+	states, ok := t.drains[drain]
+	if !ok {
+		states = make(map[*serviceLevelObjective]*sloState)
+		t.drains[drain] = states
+	}
+
+	for _, slo := range slos {
+		if !slo.matches(host, method, segments) {
+			continue
+		}
+		state, ok := states[slo]
+		if !ok {
+			state = newSloState(slo)
+			states[slo] = state
+		}
+
+		bad := isError || status >= 500 || (slo.LatencyMs > 0 && latency > slo.LatencyMs)
+		state.minutes.Add(at, bad)
+		state.hours.Add(at, bad)
+	}
+}
+
+// Returns where every objective with traffic stands at now.
+func (t *SloTracker) Flush(now time.Time) []*sloStatus {
+	t.Lock()
+	defer t.Unlock()
+
+	statuses := make([]*sloStatus, 0)
+	for drain, states := range t.drains {
+		for _, state := range states {
+			status := state.status(now)
+			status.sourceDrain = drain
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+// Returns where the objectives of drain stand, by name.
+func (t *SloTracker) Statuses(drain string, now time.Time) []sloStatus {
+	t.Lock()
+	defer t.Unlock()
+
+	statuses := make([]sloStatus, 0, len(t.drains[drain]))
+	for _, state := range t.drains[drain] {
+		statuses = append(statuses, *state.status(now))
+	}
+	sort.Sort(bySloName(statuses))
+	return statuses
+}
+
+type bySloName []sloStatus
+
+func (s bySloName) Len() int           { return len(s) }
+func (s bySloName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s bySloName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// Lists the error budgets of the objectives of ?drain=<token>
+func serveSlos(w http.ResponseWriter, r *http.Request) {
+	drain := r.URL.Query().Get("drain")
+	if drain == "" {
+		http.Error(w, "drain parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sloTracker.Statuses(drain, time.Now()))
+}
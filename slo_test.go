@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSloBurnRate(t *testing.T) {
+	configs, err := parseTenantConfigs([]byte(`{"d.1": {"slos": [
+		{"name": "api", "method": "GET", "route": "/api/*", "objective": 99, "latency_ms": 500}
+	]}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	slo := configs["d.1"].Slos[0]
+	state := newSloState(slo)
+	now := time.Now()
+
+	// A day of good traffic, then 10 minutes of every request being slow
+	for i := 0; i < 24*60; i++ {
+		at := now.Add(-time.Duration(i+10) * time.Minute)
+		for j := 0; j < 10; j++ {
+			state.minutes.Add(at, false)
+			state.hours.Add(at, false)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		at := now.Add(-time.Duration(i) * time.Minute)
+		for j := 0; j < 10; j++ {
+			state.minutes.Add(at, true)
+			state.hours.Add(at, true)
+		}
+	}
+
+	status := state.status(now)
+	if status.Requests != 24*60*10+100 || status.BadRequests != 100 {
+		t.Errorf("Unexpected counts %+v", status)
+	}
+	if status.State != "critical" {
+		t.Errorf("Expected a fast burn, got %+v", status)
+	}
+	if status.BudgetRemaining < 0.3 || status.BudgetRemaining > 0.32 {
+		t.Errorf("Expected about 31%% of the budget left, got %+v", status)
+	}
+
+	if !slo.matches("example.com", "get", splitPath("/api/users")) || slo.matches("example.com", "POST", splitPath("/api/users")) {
+		t.Errorf("Unexpected matching of %+v", slo)
+	}
+}
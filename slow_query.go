@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Fingerprints tracked per drain, later ones are counted as "other"
+	slowQueryFingerprints = 1000
+	// Durations kept per fingerprint for the p95
+	slowQuerySamples = 500
+	// Longest example query kept per fingerprint
+	slowQueryExampleLength = 1000
+)
+
+var (
+	// [DATABASE] [14-1] LOG:  duration: 2345.6 ms  statement: SELECT ...
+	postgresDuration = regexp.MustCompile(`duration: ([\d.]+) ms\s+(?:statement|execute [^:]*): (.*)$`)
+
+	sqlStrings      = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlNumbers      = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	sqlPlaceholders = regexp.MustCompile(`\$\d+`)
+	sqlInLists      = regexp.MustCompile(`(?i)\bIN\s*\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	sqlValuesLists  = regexp.MustCompile(`(?i)\bVALUES\s*\(\s*\?(?:\s*,\s*\?)*\s*\)(?:\s*,\s*\(\s*\?(?:\s*,\s*\?)*\s*\))*`)
+	sqlWhitespace   = regexp.MustCompile(`\s+`)
+)
+
+type slowQueryMsg struct {
+	Duration float64 // ms
+	Query    string
+
+	timestamp   int64
+	sourceDrain string
+}
+
+func parseBytesToSlowQuery(msg []byte) (slowQueryMsg, bool) {
+	// Logplex frames end lines in a newline the pattern doesn't expect
+	m := postgresDuration.FindSubmatch(bytes.TrimRight(msg, "\r\n"))
+	if m == nil {
+		return slowQueryMsg{}, false
+	}
+
+	sq := slowQueryMsg{Query: string(m[2])}
+	sq.Duration, _ = strconv.ParseFloat(string(m[1]), 64)
+	return sq, true
+}
+
+// Strips the literals from query so that queries differing only in their
+// parameters end up the same.
+func normalizeQuery(query string) string {
+	query = sqlStrings.ReplaceAllString(query, "?")
+	query = sqlPlaceholders.ReplaceAllString(query, "?")
+	query = sqlNumbers.ReplaceAllString(query, "?")
+	query = sqlInLists.ReplaceAllString(query, "IN (?)")
+	query = sqlValuesLists.ReplaceAllString(query, "VALUES (?)")
+	query = sqlWhitespace.ReplaceAllString(query, " ")
+	return strings.TrimSuffix(strings.TrimSpace(query), ";")
+}
+
+func queryFingerprint(normalized string) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(strings.ToLower(normalized))))
+}
+
+// Windowed and running totals for one fingerprint
+type queryStats struct {
+	Fingerprint string  `json:"fingerprint"`
+	Query       string  `json:"query"`
+	Example     string  `json:"example"`
+	Count       int     `json:"count"`
+	TotalTime   float64 `json:"total_ms"`
+	P95         float64 `json:"p95_ms"`
+	Max         float64 `json:"max_ms"`
+
+	durations *reservoir
+
+	windowCount int
+	windowTotal float64
+	windowMax   float64
+	windowTimes *reservoir
+}
+
+// Per fingerprint totals for one aggregation window, sent to the sinks
+type slowQueryRollup struct {
+	Fingerprint string
+	Query       string
+	Count       int
+	TotalTime   float64
+	P95         float64
+	Max         float64
+
+	timestamp   int64
+	sourceDrain string
+}
+
+// SlowQueries groups Postgres slow query log lines by fingerprint.
+type SlowQueries struct {
+	sync.Mutex
+	drains map[string]map[string]*queryStats
+}
+
+func NewSlowQueries() *SlowQueries {
+	return &SlowQueries{drains: make(map[string]map[string]*queryStats)}
+}
+
+func (q *SlowQueries) Observe(sq *slowQueryMsg) {
+	normalized := normalizeQuery(sq.Query)
+	fingerprint := queryFingerprint(normalized)
+
+	q.Lock()
+	defer q.Unlock()
+
+	queries, ok := q.drains[sq.sourceDrain]
+	if !ok {
+		queries = make(map[string]*queryStats)
+		q.drains[sq.sourceDrain] = queries
+	}
+
+	// The example is kept normalized too, raw literals can hold tenant data
+	example := normalized
+	stats, ok := queries[fingerprint]
+	if !ok {
+		if len(queries) >= slowQueryFingerprints {
+			fingerprint, normalized = "other", "other"
+			stats = queries[fingerprint]
+		}
+		if stats == nil {
+			if len(example) > slowQueryExampleLength {
+				example = example[:slowQueryExampleLength]
+			}
+			stats = &queryStats{
+				Fingerprint: fingerprint,
+				Query:       normalized,
+				Example:     example,
+				durations:   newReservoir(slowQuerySamples),
+				windowTimes: newReservoir(slowQuerySamples),
+			}
+			queries[fingerprint] = stats
+		}
+	}
+
+	stats.Count++
+	stats.TotalTime += sq.Duration
+	if sq.Duration > stats.Max {
+		stats.Max = sq.Duration
+	}
+	stats.durations.Add(sq.Duration)
+
+	stats.windowCount++
+	stats.windowTotal += sq.Duration
+	if sq.Duration > stats.windowMax {
+		stats.windowMax = sq.Duration
+	}
+	stats.windowTimes.Add(sq.Duration)
+}
+
+// Returns the fingerprints seen in the current window and starts a new one.
+func (q *SlowQueries) Flush(now time.Time) []*slowQueryRollup {
+	q.Lock()
+	defer q.Unlock()
+
+	timestamp := now.UnixNano() / int64(time.Microsecond)
+	rollups := make([]*slowQueryRollup, 0)
+	for drain, queries := range q.drains {
+		for _, stats := range queries {
+			if stats.windowCount == 0 {
+				continue
+			}
+			rollups = append(rollups, &slowQueryRollup{
+				Fingerprint: stats.Fingerprint,
+				Query:       stats.Query,
+				Count:       stats.windowCount,
+				TotalTime:   stats.windowTotal,
+				P95:         percentile(stats.windowTimes.values, 0.95),
+				Max:         stats.windowMax,
+				timestamp:   timestamp,
+				sourceDrain: drain,
+			})
+			stats.windowCount, stats.windowTotal, stats.windowMax = 0, 0, 0
+			stats.windowTimes = newReservoir(slowQuerySamples)
+		}
+	}
+	return rollups
+}
+
+// Returns the n fingerprints of drain that took the most time overall.
+func (q *SlowQueries) Top(drain string, n int) []queryStats {
+	q.Lock()
+	defer q.Unlock()
+
+	top := make([]queryStats, 0, len(q.drains[drain]))
+	for _, stats := range q.drains[drain] {
+		s := *stats
+		s.P95 = percentile(append([]float64(nil), stats.durations.values...), 0.95)
+		top = append(top, s)
+	}
+	sort.Sort(byTotalTime(top))
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+type byTotalTime []queryStats
+
+func (s byTotalTime) Len() int           { return len(s) }
+func (s byTotalTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byTotalTime) Less(i, j int) bool { return s[i].TotalTime > s[j].TotalTime }
+
+// Lists the slowest queries of ?drain=<token> by total time, &n=<count> of
+// them (default 20).
+func serveSlowQueries(w http.ResponseWriter, r *http.Request) {
+	drain := r.URL.Query().Get("drain")
+	if drain == "" {
+		http.Error(w, "drain parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n <= 0 {
+		n = 20
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(slowQueries.Top(drain, n))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	testCases := map[string]string{
+		"SELECT * FROM users WHERE id = 42":                                   "SELECT * FROM users WHERE id = ?",
+		"SELECT * FROM users WHERE email = 'bob@example.com' AND age > 21.5;": "SELECT * FROM users WHERE email = ? AND age > ?",
+		"SELECT * FROM users WHERE id IN (1, 2, 3)":                           "SELECT * FROM users WHERE id IN (?)",
+		"INSERT INTO t (a, b) VALUES ($1, $2), ($3, $4)":                      "INSERT INTO t (a, b) VALUES (?)",
+		"SELECT  name\n  FROM users2 WHERE note = 'it''s'":                    "SELECT name FROM users2 WHERE note = ?",
+	}
+
+	for query, expected := range testCases {
+		if normalized := normalizeQuery(query); normalized != expected {
+			t.Errorf("Normalizing %q yielded %q, expected %q", query, normalized, expected)
+		}
+	}
+
+	if queryFingerprint(normalizeQuery("select 1")) != queryFingerprint(normalizeQuery("SELECT 2")) {
+		t.Errorf("Queries differing in literals and case should share a fingerprint")
+	}
+}
+
+func TestParseSlowQuery(t *testing.T) {
+	sq, ok := parseBytesToSlowQuery([]byte("[DATABASE] [14-1] LOG:  duration: 2345.6 ms  statement: SELECT * FROM users"))
+	if !ok {
+		t.Fatalf("Expected the slow query line to be recognized")
+	}
+	if sq.Duration != 2345.6 || sq.Query != "SELECT * FROM users" {
+		t.Errorf("Unexpected slow query %+v", sq)
+	}
+}
+
+func TestDrainSlowQuery(t *testing.T) {
+	token := "d.slow-query-test"
+	postToDrain(t, token,
+		"<134>1 2014-07-02T16:52:38.123456+00:00 host app postgres.1234 - [DATABASE] [14-1] LOG:  duration: 2345.6 ms  statement: SELECT * FROM users WHERE id = 42",
+	)
+
+	top := slowQueries.Top(token, 10)
+	if len(top) != 1 {
+		t.Fatalf("Expected the slow query to be recorded, got %+v", top)
+	}
+	if top[0].Query != "SELECT * FROM users WHERE id = ?" || top[0].TotalTime != 2345.6 {
+		t.Errorf("Unexpected slow query %+v", top[0])
+	}
+	if top[0].Example != "SELECT * FROM users WHERE id = ?" {
+		t.Errorf("Expected the example to have its literals stripped, got %q", top[0].Example)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// Returns the p-th percentile (0 < p <= 1) of values using the nearest rank
+// method. values is sorted in place.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+
+	rank := int(p*float64(len(values))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(values) {
+		rank = len(values) - 1
+	}
+	return values[rank]
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func maximum(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	m := values[0]
+	for _, v := range values[1:] {
+		if v > m {
+			m = v
+		}
+	}
+	return m
+}
+
+func mapValues(m map[string]float64) []float64 {
+	values := make([]float64, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	return values
+}
+
+// Returns a / b, or 0 if b is 0.
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
+
+// A fixed size uniform sample of a stream of values, so percentiles over busy
+// windows don't need every value kept around.
+type reservoir struct {
+	size   int
+	seen   int
+	values []float64
+}
+
+func newReservoir(size int) *reservoir {
+	return &reservoir{size: size, values: make([]float64, 0)}
+}
+
+func (r *reservoir) Add(v float64) {
+	r.seen++
+	if len(r.values) < r.size {
+		r.values = append(r.values, v)
+	} else if i := rand.Intn(r.seen); i < r.size {
+		r.values[i] = v
+	}
+}
+
+// Fits y = slope*x + intercept by least squares. Returns a zero slope if all
+// xs are the same.
+func linearRegression(xs, ys []float64) (slope, intercept float64) {
+	mx, my := mean(xs), mean(ys)
+
+	var cov, variance float64
+	for i := range xs {
+		cov += (xs[i] - mx) * (ys[i] - my)
+		variance += (xs[i] - mx) * (xs[i] - mx)
+	}
+	if variance == 0 {
+		return 0, my
+	}
+
+	slope = cov / variance
+	return slope, my - slope*mx
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLinearRegression(t *testing.T) {
+	slope, intercept := linearRegression([]float64{0, 1, 2, 3}, []float64{1, 3, 5, 7})
+	if slope != 2 || intercept != 1 {
+		t.Errorf("Expected y = 2x + 1, got y = %fx + %f", slope, intercept)
+	}
+
+	slope, intercept = linearRegression([]float64{5, 5}, []float64{1, 3})
+	if slope != 0 || intercept != 2 {
+		t.Errorf("Expected a flat line through the mean without spread in x, got y = %fx + %f", slope, intercept)
+	}
+}
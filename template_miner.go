@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Templates tracked per drain, later lines are counted as "other"
+	templatesPerDrain = 1000
+	// Share of tokens a line must have in common with a template to join it
+	templateSimilarity = 0.5
+	// Lines with more tokens than this are cut before mining
+	templateMaxTokens = 64
+	// Longest example line kept per template
+	templateExampleLength = 1000
+
+	templateWildcard = "<*>"
+)
+
+// A cluster of unknown lines that only differ in their variable tokens
+type logTemplate struct {
+	Id       string    `json:"id"`
+	Source   string    `json:"source"`
+	Template string    `json:"template"`
+	Example  string    `json:"example"`
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+
+	tokens []string
+}
+
+// TemplateMiner clusters the lines no parser recognized into templates, in
+// the style of the Drain algorithm: lines are bucketed by where they come
+// from, their number of tokens and their first token, and join the most
+// similar template of their bucket, whose differing tokens become wildcards.
+type TemplateMiner struct {
+	sync.Mutex
+	drains map[string]*drainTemplates
+}
+
+type drainTemplates struct {
+	count   int
+	buckets map[string][]*logTemplate
+}
+
+func NewTemplateMiner() *TemplateMiner {
+	return &TemplateMiner{drains: make(map[string]*drainTemplates)}
+}
+
+// Tokens with digits in them are ids, counts, durations and timestamps far
+// more often than not, so they are wildcards from the start.
+func templateTokens(msg []byte) []string {
+	tokens := strings.Fields(string(msg))
+	if len(tokens) > templateMaxTokens {
+		tokens = tokens[:templateMaxTokens]
+	}
+	for i, token := range tokens {
+		if strings.IndexAny(token, "0123456789") >= 0 {
+			tokens[i] = templateWildcard
+		}
+	}
+	return tokens
+}
+
+// Share of tokens at the same position that are equal. Wildcards in the
+// template don't count for or against the line.
+func templateSimilarityOf(template, tokens []string) float64 {
+	equal, compared := 0, 0
+	for i, token := range template {
+		if token == templateWildcard {
+			continue
+		}
+		compared++
+		if token == tokens[i] {
+			equal++
+		}
+	}
+	if compared == 0 {
+		return 1
+	}
+	return float64(equal) / float64(compared)
+}
+
+// Adds an unknown line from source (e.g. "heroku/api" or "app/web") of drain
+// to its template.
+func (m *TemplateMiner) Observe(drain, source string, msg []byte, now time.Time) {
+	tokens := templateTokens(msg)
+	if len(tokens) == 0 {
+		return
+	}
+
+	first := tokens[0]
+	bucketKey := source + "\x00" + strconv.Itoa(len(tokens)) + "\x00" + first
+
+	m.Lock()
+	defer m.Unlock()
+
+	templates, ok := m.drains[drain]
+	if !ok {
+		templates = &drainTemplates{buckets: make(map[string][]*logTemplate)}
+		m.drains[drain] = templates
+	}
+
+	var best *logTemplate
+	bestSimilarity := 0.0
+	for _, t := range templates.buckets[bucketKey] {
+		if similarity := templateSimilarityOf(t.tokens, tokens); similarity > bestSimilarity {
+			best, bestSimilarity = t, similarity
+		}
+	}
+
+	if best == nil || bestSimilarity < templateSimilarity {
+		if templates.count >= templatesPerDrain {
+			source, bucketKey, tokens = "other", "other", []string{"other"}
+			if others := templates.buckets[bucketKey]; len(others) > 0 {
+				best = others[0]
+			}
+		} else {
+			best = nil
+		}
+
+		if best == nil {
+			example := string(msg)
+			if len(example) > templateExampleLength {
+				example = example[:templateExampleLength]
+			}
+			best = &logTemplate{Source: source, Example: example, tokens: tokens}
+			templates.buckets[bucketKey] = append(templates.buckets[bucketKey], best)
+			templates.count++
+		}
+	} else {
+		for i, token := range best.tokens {
+			if token != tokens[i] {
+				best.tokens[i] = templateWildcard
+			}
+		}
+	}
+
+	best.Count++
+	best.LastSeen = now
+}
+
+// Returns the n templates of drain that matched the most lines.
+func (m *TemplateMiner) Top(drain string, n int) []logTemplate {
+	m.Lock()
+	defer m.Unlock()
+
+	top := make([]logTemplate, 0)
+	if templates, ok := m.drains[drain]; ok {
+		for _, bucket := range templates.buckets {
+			for _, t := range bucket {
+				template := *t
+				template.Template = strings.Join(t.tokens, " ")
+				template.Id = fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(t.Source+"\x00"+template.Template)))
+				template.tokens = nil
+				top = append(top, template)
+			}
+		}
+	}
+	sort.Sort(byTemplateCount(top))
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+type byTemplateCount []logTemplate
+
+func (s byTemplateCount) Len() int           { return len(s) }
+func (s byTemplateCount) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byTemplateCount) Less(i, j int) bool { return s[i].Count > s[j].Count }
+
+// Lists the templates of the unknown lines of ?drain=<token> that matched the
+// most lines, &n=<count> of them (default 20).
+func serveTemplates(w http.ResponseWriter, r *http.Request) {
+	drain := r.URL.Query().Get("drain")
+	if drain == "" {
+		http.Error(w, "drain parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n <= 0 {
+		n = 20
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templateMiner.Top(drain, n))
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTemplateMiner(t *testing.T) {
+	miner := NewTemplateMiner()
+	now := time.Now()
+	lines := []string{
+		"Cache miss key=user:12 took 3ms",
+		"Cache miss key=user:40 took 12ms",
+		"Cache miss key=account:7 took 1ms",
+		"Connection reset by peer",
+	}
+	for _, line := range lines {
+		miner.Observe("d.1", "app/web", []byte(line), now)
+	}
+
+	top := miner.Top("d.1", 10)
+	if len(top) != 2 {
+		t.Fatalf("Expected 2 templates, got %+v", top)
+	}
+	if top[0].Template != "Cache miss <*> took <*>" || top[0].Count != 3 {
+		t.Errorf("Unexpected template %+v", top[0])
+	}
+	if top[1].Template != "Connection reset by peer" || top[1].Count != 1 {
+		t.Errorf("Unexpected template %+v", top[1])
+	}
+
+	if top := miner.Top("d.2", 10); len(top) != 0 {
+		t.Errorf("Expected no templates for another drain, got %+v", top)
+	}
+}
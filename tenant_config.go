@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// Settings of one tenant, i.e. one drain token
+type tenantConfig struct {
+	Rules  []*extractionRule        `json:"rules"`
+	Routes []string                 `json:"routes"`
+	Slos   []*serviceLevelObjective `json:"slos"`
+	ApdexT float64                  `json:"apdex_t_ms"`
+
+	// Requests a dyno of each process type can serve at once, e.g. Puma
+	// threads times workers
+	Workers map[string]int `json:"workers"`
+
+	// Seconds dynos of each process type may take to boot
+	BootTimeouts map[string]float64 `json:"boot_timeouts"`
+
+	// Connection limits of the tenant's add-on plans, 0 for the default
+	PostgresConnectionLimit int `json:"postgres_connection_limit"`
+	RedisConnectionLimit    int `json:"redis_connection_limit"`
+
+	routes []*routePattern
+}
+
+// TenantConfigs maps drain tokens to their settings. The "*" entry applies to
+// drains without an entry of their own.
+type TenantConfigs map[string]*tenantConfig
+
+var defaultTenantConfig = &tenantConfig{}
+
+func parseTenantConfigs(data []byte) (TenantConfigs, error) {
+	configs := make(TenantConfigs)
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, err
+	}
+
+	for drain, config := range configs {
+		if config == nil {
+			return nil, fmt.Errorf("tenant %s: no settings", drain)
+		}
+		for _, rule := range config.Rules {
+			if err := rule.compile(); err != nil {
+				return nil, fmt.Errorf("tenant %s: %s", drain, err)
+			}
+		}
+		for _, pattern := range config.Routes {
+			rp, err := compileRoutePattern(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("tenant %s: %s", drain, err)
+			}
+			config.routes = append(config.routes, rp)
+		}
+		for _, slo := range config.Slos {
+			if err := slo.compile(); err != nil {
+				return nil, fmt.Errorf("tenant %s: %s", drain, err)
+			}
+		}
+		for processType, seconds := range config.BootTimeouts {
+			if seconds <= 0 {
+				return nil, fmt.Errorf("tenant %s: boot timeout of %s must be positive", drain, processType)
+			}
+		}
+		if config.PostgresConnectionLimit < 0 || config.RedisConnectionLimit < 0 {
+			return nil, fmt.Errorf("tenant %s: connection limits can't be negative", drain)
+		}
+	}
+	return configs, nil
+}
+
+// Reads the tenant settings from the JSON file at path. Without a path every
+// tenant gets the defaults. A broken file stops lumbermill rather than have it
+// silently ignore the settings.
+func loadTenantConfigs(path string) TenantConfigs {
+	if path == "" {
+		return make(TenantConfigs)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Unable to read tenant config %s: %s\n", path, err)
+	}
+	configs, err := parseTenantConfigs(data)
+	if err != nil {
+		log.Fatalf("Unable to parse tenant config %s: %s\n", path, err)
+	}
+	return configs
+}
+
+// Returns the settings of drain
+func (c TenantConfigs) For(drain string) *tenantConfig {
+	if config, ok := c[drain]; ok {
+		return config
+	}
+	if config, ok := c["*"]; ok {
+		return config
+	}
+	return defaultTenantConfig
+}